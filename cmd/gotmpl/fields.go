@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+// fields prints every data path referenced by the given template file, one per line, and returns the exit code
+func fields(file string) int {
+
+	tmplBytes, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	paths, err := (template.Engine{}).Fields(string(tmplBytes))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+	return 0
+}
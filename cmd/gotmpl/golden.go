@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/schema"
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+	"github.com/pmezard/go-difflib/difflib"
+	"sigs.k8s.io/yaml"
+)
+
+// testManifestNames are the file names of the test manifest which is looked for in each directory
+var testManifestNames = []string{"gotmpl-test.yaml", "gotmpl-test.yml"}
+
+// testDataExtensions are the data file extensions which are matched to a template with the same name
+var testDataExtensions = []string{".json", ".yaml", ".yml", ".xml"}
+
+// goldenExtension is added to the data file name to get the expected output file name
+const goldenExtension = ".golden"
+
+// testCase is a template, data and expected output; all paths are relative to the current directory
+type testCase struct {
+	Name       string `json:"name"`
+	Template   string `json:"template"`
+	Data       string `json:"data"`
+	Expected   string `json:"expected"`
+	Schema     string `json:"schema,omitempty"`
+	MissingKey string `json:"missingkey,omitempty"`
+}
+
+// testManifest lists test cases; all paths are relative to the directory of the manifest
+type testManifest struct {
+	Tests []testCase `json:"tests"`
+}
+
+// test finds and runs all test cases in the given directories (recursively), prints the result
+// of each (with a diff for any failures) and returns the exit code: 1 if any failed, otherwise 0.
+// If update is true, the expected output files are written instead of compared.
+func test(dirs []string, update bool) int {
+
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	cases := []testCase{}
+	for _, dir := range dirs {
+		found, err := findTestCases(dir)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		cases = append(cases, found...)
+	}
+	if len(cases) == 0 {
+		fmt.Println("no test cases found")
+		return 1
+	}
+
+	failed := 0
+	for _, tc := range cases {
+		diff, err := runTestCase(tc, update)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL    %s\n        %s\n", tc.Name, err)
+		case diff != "":
+			failed++
+			fmt.Printf("FAIL    %s\n%s", tc.Name, diff)
+		case update:
+			fmt.Printf("UPDATED %s\n", tc.Name)
+		default:
+			fmt.Printf("PASS    %s\n", tc.Name)
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", len(cases)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// findTestCases walks dir and returns the test cases of each directory, which are either
+// listed in a test manifest or (if there is no manifest) found by file name:
+// each template NAME.tmpl is rendered with each data file NAME.json, NAME.yaml, NAME.yml
+// or NAME.xml and compared with the expected output in the data file name + ".golden"
+func findTestCases(dir string) ([]testCase, error) {
+
+	cases := []testCase{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		for _, manifestName := range testManifestNames {
+			manifestPath := filepath.Join(path, manifestName)
+			manifestBytes, err := os.ReadFile(manifestPath)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var manifest testManifest
+			if err := yaml.Unmarshal(manifestBytes, &manifest); err != nil {
+				return fmt.Errorf("could not read test manifest '%s': %w", manifestPath, err)
+			}
+			for i, tc := range manifest.Tests {
+				if tc.Name == "" {
+					tc.Name = fmt.Sprintf("%s[%d]", manifestPath, i)
+				} else {
+					tc.Name = filepath.Join(path, tc.Name)
+				}
+				tc.Template = filepath.Join(path, tc.Template)
+				tc.Data = filepath.Join(path, tc.Data)
+				tc.Expected = filepath.Join(path, tc.Expected)
+				if tc.Schema != "" {
+					tc.Schema = filepath.Join(path, tc.Schema)
+				}
+				cases = append(cases, tc)
+			}
+			return nil
+		}
+
+		templates, err := filepath.Glob(filepath.Join(path, "*.tmpl"))
+		if err != nil {
+			return err
+		}
+		for _, tmplPath := range templates {
+			base := strings.TrimSuffix(tmplPath, ".tmpl")
+			for _, ext := range testDataExtensions {
+				if _, err := os.Stat(base + ext); err != nil {
+					continue
+				}
+				cases = append(cases, testCase{
+					Name:     base + ext,
+					Template: tmplPath,
+					Data:     base + ext,
+					Expected: base + ext + goldenExtension,
+				})
+			}
+		}
+		return nil
+	})
+	return cases, err
+}
+
+// runTestCase renders a test case and returns a unified diff if the output does not match the expected output.
+// If update is true the expected output file is written with the output instead.
+func runTestCase(tc testCase, update bool) (diff string, err error) {
+
+	missingKey, err := template.ParseMissingKey(tc.MissingKey)
+	if err != nil {
+		return "", err
+	}
+	engine := template.Engine{MissingKey: missingKey}
+
+	tmplBytes, err := os.ReadFile(tc.Template)
+	if err != nil {
+		return "", err
+	}
+	data, err := readData(tc.Data)
+	if err != nil {
+		return "", err
+	}
+
+	if tc.Schema != "" {
+		schemaBytes, err := os.ReadFile(tc.Schema)
+		if err != nil {
+			return "", err
+		}
+		s, err := schema.Compile(filepath.Base(tc.Schema), schemaBytes)
+		if err != nil {
+			return "", err
+		}
+		if err := s.Validate(data); err != nil {
+			return "", err
+		}
+	}
+
+	// If text/template parsing fails it will panic; return it as an error for this test case instead
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	var output bytes.Buffer
+	if err := engine.Render(string(tmplBytes), data, &output); err != nil {
+		return "", err
+	}
+
+	if update {
+		return "", os.WriteFile(tc.Expected, output.Bytes(), 0644)
+	}
+
+	expected, err := os.ReadFile(tc.Expected)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("expected output file '%s' does not exist (use --update to create it)", tc.Expected)
+	}
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(expected, output.Bytes()) {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(expected)),
+		B:        splitLines(output.String()),
+		FromFile: tc.Expected,
+		ToFile:   "rendered output",
+		Context:  3,
+	})
+}
+
+// splitLines splits s into lines which keep their line endings (as expected by difflib)
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
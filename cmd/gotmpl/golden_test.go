@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFiles writes files (by path relative to dir) with the given contents
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755)) || !assert.NoError(t, os.WriteFile(path, []byte(content), 0644)) {
+			t.FailNow()
+		}
+	}
+}
+
+func TestFindTestCases(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tmpl":         `{{ .a }}`,
+		"a.json":         `{"a": 1}`,
+		"a.yaml":         `a: 2`,
+		"b.tmpl":         `{{ .b }}`,
+		"sub/c.tmpl":     `{{ .c }}`,
+		"sub/c.xml":      `<c>3</c>`,
+		".hidden/d.tmpl": `{{ .d }}`,
+		".hidden/d.json": `{"d": 4}`,
+		"manifest/gotmpl-test.yaml": `
+tests:
+  - name: first
+    template: t.tmpl
+    data: d.json
+    expected: out.txt
+    schema: s.json
+    missingkey: zero
+  - template: t.tmpl
+    data: other.json
+    expected: other.txt
+`,
+		"manifest/ignored.tmpl": `{{ .x }}`,
+		"manifest/ignored.json": `{}`,
+	})
+
+	cases, err := findTestCases(dir)
+	assert.NoError(t, err)
+	manifest := filepath.Join(dir, "manifest")
+	assert.Equal(t, []testCase{
+		{Name: filepath.Join(dir, "a.json"), Template: filepath.Join(dir, "a.tmpl"), Data: filepath.Join(dir, "a.json"), Expected: filepath.Join(dir, "a.json.golden")},
+		{Name: filepath.Join(dir, "a.yaml"), Template: filepath.Join(dir, "a.tmpl"), Data: filepath.Join(dir, "a.yaml"), Expected: filepath.Join(dir, "a.yaml.golden")},
+		{Name: filepath.Join(manifest, "first"), Template: filepath.Join(manifest, "t.tmpl"), Data: filepath.Join(manifest, "d.json"), Expected: filepath.Join(manifest, "out.txt"), Schema: filepath.Join(manifest, "s.json"), MissingKey: "zero"},
+		{Name: filepath.Join(manifest, "gotmpl-test.yaml") + "[1]", Template: filepath.Join(manifest, "t.tmpl"), Data: filepath.Join(manifest, "other.json"), Expected: filepath.Join(manifest, "other.txt")},
+		{Name: filepath.Join(dir, "sub", "c.xml"), Template: filepath.Join(dir, "sub", "c.tmpl"), Data: filepath.Join(dir, "sub", "c.xml"), Expected: filepath.Join(dir, "sub", "c.xml.golden")},
+	}, cases)
+
+	writeFiles(t, dir, map[string]string{"manifest/gotmpl-test.yaml": `tests: {`})
+	_, err = findTestCases(dir)
+	assert.ErrorContains(t, err, "could not read test manifest")
+}
+
+func TestRunTestCase(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tmpl": "Hi {{ .name }}\nBye\n",
+		"a.json": `{"name": "a"}`,
+	})
+	tc := testCase{
+		Name:     "a",
+		Template: filepath.Join(dir, "a.tmpl"),
+		Data:     filepath.Join(dir, "a.json"),
+		Expected: filepath.Join(dir, "a.json.golden"),
+	}
+
+	// the expected output must exist unless it is being updated
+	_, err := runTestCase(tc, false)
+	assert.ErrorContains(t, err, "use --update to create it")
+
+	diff, err := runTestCase(tc, true)
+	assert.NoError(t, err)
+	assert.Empty(t, diff)
+	b, err := os.ReadFile(tc.Expected)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi a\nBye\n", string(b))
+
+	diff, err = runTestCase(tc, false)
+	assert.NoError(t, err)
+	assert.Empty(t, diff)
+
+	// a different output is shown as a unified diff of the expected and rendered output
+	writeFiles(t, dir, map[string]string{"a.json": `{"name": "b"}`})
+	diff, err = runTestCase(tc, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "--- "+tc.Expected+"\n+++ rendered output\n@@ -1,2 +1,2 @@\n-Hi a\n+Hi b\n Bye\n", diff)
+
+	// missing keys are errors unless the test case has another missingkey policy
+	writeFiles(t, dir, map[string]string{"a.json": `{}`})
+	_, err = runTestCase(tc, false)
+	assert.Error(t, err)
+	tc.MissingKey = "zero"
+	diff, err = runTestCase(tc, false)
+	assert.NoError(t, err)
+	assert.Contains(t, diff, "+Hi <no value>\n")
+}
+
+func TestGoldenTest(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.tmpl": `{{ .a }}`,
+		"a.json": `{"a": 1}`,
+	})
+
+	assert.Equal(t, 1, test([]string{dir}, false))
+	assert.Equal(t, 0, test([]string{dir}, true))
+	assert.Equal(t, 0, test([]string{dir}, false))
+	assert.Equal(t, 1, test([]string{t.TempDir()}, false))
+}
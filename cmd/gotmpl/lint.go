@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+// lint checks the given template files as one set, prints any issues found and returns the exit code:
+// 1 if there were any errors (or warnings in strict mode), otherwise 0
+func lint(files []string, strict bool) int {
+
+	sources := []template.Source{}
+	for _, file := range files {
+		tmplBytes, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		sources = append(sources, template.Source{Name: file, Text: string(tmplBytes)})
+	}
+
+	exitCode := 0
+	for _, issue := range (template.Engine{}).Lint(sources...) {
+		fmt.Println(issue)
+		if issue.Severity == template.SeverityError || strict {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
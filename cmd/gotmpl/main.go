@@ -1,16 +1,15 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 
-	"github.com/clbanning/mxj/v2"
 	"github.com/docopt/docopt-go"
 	"github.com/joshuagrisham-karolinska/gotmpl"
+	"github.com/joshuagrisham-karolinska/gotmpl/dataformat"
+	"github.com/joshuagrisham-karolinska/gotmpl/schema"
 	"github.com/joshuagrisham-karolinska/gotmpl/template"
-	"sigs.k8s.io/yaml"
 )
 
 func main() {
@@ -18,18 +17,54 @@ func main() {
 	// Set up and parse options
 	usage := `Render a Go text template using the given data file.
 Usage:
-  gotmpl (--template <path> --data <path>)
+  gotmpl (--template <path> --data <path>) [--schema <path>] [--missingkey <policy>] [--report-missing]
+  gotmpl lint [--strict] <file>...
+  gotmpl fields <template>
+  gotmpl test [--update] [<dir>...]
   gotmpl --help | --version
 
 Options:
-  -h --help             Show this screen.
-  -v --version          Show version.
-  -t --template <path>  Template file path.
-  -d --data <path>      Data file path (supports JSON, YAML, and XML).`
+  -h --help                 Show this screen.
+  -v --version              Show version.
+  -t --template <path>      Template file path.
+  -d --data <path>          Data file path (supports JSON, YAML, and XML).
+  -s --schema <path>        JSON Schema file path to validate the data against before rendering.
+  -m --missingkey <policy>  What to do with keys missing from the data: error, zero, default or invalid [default: error].
+  --report-missing          Print the keys which were missing from the data to stderr (useful with a lenient --missingkey).
+  --strict                  Exit with a non-zero status for lint warnings as well as errors.
+  -u --update               Write the rendered output to the expected output files instead of comparing.`
 
 	opts, _ := docopt.ParseArgs(usage, os.Args[1:], gotmpl.Version)
+
+	if isLint, _ := opts.Bool("lint"); isLint {
+		files := opts["<file>"].([]string)
+		strict, _ := opts.Bool("--strict")
+		os.Exit(lint(files, strict))
+	}
+
+	if isFields, _ := opts.Bool("fields"); isFields {
+		file, _ := opts.String("<template>")
+		os.Exit(fields(file))
+	}
+
+	if isTest, _ := opts.Bool("test"); isTest {
+		dirs := opts["<dir>"].([]string)
+		update, _ := opts.Bool("--update")
+		os.Exit(test(dirs, update))
+	}
+
 	tmplPath, _ := opts.String("--template")
 	dataPath, _ := opts.String("--data")
+	schemaPath, _ := opts.String("--schema")
+	missingKeyString, _ := opts.String("--missingkey")
+	reportMissing, _ := opts.Bool("--report-missing")
+
+	missingKey, err := template.ParseMissingKey(missingKeyString)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	engine := template.Engine{MissingKey: missingKey}
 
 	// Read template from file system
 	tmplBytes, err := os.ReadFile(tmplPath)
@@ -39,35 +74,71 @@ Options:
 	}
 
 	// Read data from file system
-	dataBytes, err := os.ReadFile(dataPath)
+	data, err := readData(dataPath)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	data := make(map[string]interface{})
-	switch filepath.Ext(dataPath) {
-	case ".json":
-		err = json.Unmarshal(dataBytes, &data)
-	case ".yml", ".yaml":
-		err = yaml.Unmarshal(dataBytes, &data)
-	case ".xml":
-		// use mxj instead of encoding/xml since we want to use generic map[string]interface
-		data, err = mxj.NewMapXml(dataBytes)
-	default:
-		fmt.Printf("unsupported data file extension '%s'\n", filepath.Ext(dataPath))
-		os.Exit(1)
+	// Validate data against the schema (if given) and print all violations
+	if schemaPath != "" {
+		schemaBytes, err := os.ReadFile(schemaPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		s, err := schema.Compile(filepath.Base(schemaPath), schemaBytes)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err = s.Validate(data)
+		if ve, ok := err.(*schema.ValidationError); ok {
+			fmt.Println("data does not conform to schema:")
+			for _, v := range ve.Violations {
+				fmt.Printf("  %s\n", v)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
+
+	// Render template using data and write the result to os.Stdout
+	err = engine.Render(string(tmplBytes), data, os.Stdout)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Render template using data and write the result to os.Stdout
-	err = template.Render(string(tmplBytes), data, os.Stdout)
+	// Report any missing keys to os.Stderr so they do not get mixed up with the rendered output
+	if reportMissing {
+		missing, err := engine.MissingKeys(string(tmplBytes), data)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, key := range missing {
+			fmt.Fprintf(os.Stderr, "missing key: %s\n", key)
+		}
+	}
+
+}
+
+// readData reads and unmarshals the data file at path based on its file extension (JSON, YAML or XML)
+func readData(path string) (map[string]interface{}, error) {
+
+	format, err := dataformat.FromExtension(path)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
+	}
+
+	dataBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
 
+	return dataformat.Unmarshal(format, dataBytes)
 }
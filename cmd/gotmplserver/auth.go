@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+	"sigs.k8s.io/yaml"
+)
+
+// AuthConfig is the content of the --auth file (YAML or JSON)
+type AuthConfig struct {
+	// Clients are the clients which may use the server, and the rules for each of them
+	Clients []AuthClient `json:"clients"`
+	// JWT enables bearer tokens which are JSON Web Tokens signed by a key in a local JWKS file
+	JWT *JWTConfig `json:"jwt,omitempty"`
+}
+
+// AuthClient identifies a client by API key, client certificate or JWT, and restricts what it can do
+type AuthClient struct {
+	// Name identifies the client in logs
+	Name string `json:"name"`
+	// APIKeys are static keys which the client sends as "X-API-Key: <key>" or "Authorization: Bearer <key>"
+	APIKeys []string `json:"apiKeys,omitempty"`
+	// CommonNames are the identities (see clientIdentity) of client certificates when using mutual TLS
+	CommonNames []string `json:"commonNames,omitempty"`
+	// Subjects are patterns (e.g. "service-*") matched against the claim of a JWT
+	Subjects []string `json:"subjects,omitempty"`
+	// Templates are patterns (e.g. "reports/*") of the named templates which the client may use; all if empty
+	Templates []string `json:"templates,omitempty"`
+	// NamedOnly only allows named templates, i.e. no inline templates or linting
+	NamedOnly bool `json:"namedOnly,omitempty"`
+	// Profile restricts the client's inline and uploaded templates to this function profile; it can only make the
+	// server's profile stricter (e.g. hermetic on a server with the default profile), never looser
+	Profile template.Profile `json:"profile,omitempty"`
+}
+
+// JWTConfig configures how JSON Web Tokens are validated
+type JWTConfig struct {
+	// JWKS is the path of a JSON Web Key Set file with the public keys (relative to the auth file)
+	JWKS string `json:"jwks"`
+	// Issuer is the required iss claim, if set
+	Issuer string `json:"issuer,omitempty"`
+	// Audience is the required aud claim, if set
+	Audience string `json:"audience,omitempty"`
+	// Claim is the claim which is matched against the Subjects of each client (default: sub)
+	Claim string `json:"claim,omitempty"`
+	// AllowNoExpiry accepts tokens without an exp claim, which are otherwise rejected since they would be valid forever
+	AllowNoExpiry bool `json:"allowNoExpiry,omitempty"`
+}
+
+// auth is the loaded --auth configuration; authentication is disabled if it is nil
+var auth *authenticator
+
+type authenticator struct {
+	clients []AuthClient
+	jwt     *jwtVerifier
+	claim   string
+}
+
+// loadAuth reads and validates an auth configuration file
+func loadAuth(file string) (*authenticator, error) {
+
+	configBytes, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var config AuthConfig
+	if err := yaml.UnmarshalStrict(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("could not read auth file '%s': %w", file, err)
+	}
+
+	a := &authenticator{clients: config.Clients}
+	names := make(map[string]bool)
+	for _, c := range config.Clients {
+		if c.Name == "" {
+			return nil, fmt.Errorf("all clients in auth file '%s' must have a name", file)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("client '%s' is defined more than once in auth file '%s'", c.Name, file)
+		}
+		names[c.Name] = true
+		for _, pattern := range append(c.Subjects, c.Templates...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern '%s' for client '%s': %w", pattern, c.Name, err)
+			}
+		}
+		if c.Profile != "" {
+			if _, err := template.ParseProfile(string(c.Profile)); err != nil {
+				return nil, fmt.Errorf("client '%s': %w", c.Name, err)
+			}
+		}
+	}
+
+	if config.JWT != nil {
+		jwksPath := config.JWT.JWKS
+		if !filepath.IsAbs(jwksPath) {
+			jwksPath = filepath.Join(filepath.Dir(file), jwksPath)
+		}
+		keys, err := loadJWKS(jwksPath)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = &jwtVerifier{keys: keys, issuer: config.JWT.Issuer, audience: config.JWT.Audience, allowNoExpiry: config.JWT.AllowNoExpiry}
+		a.claim = config.JWT.Claim
+		if a.claim == "" {
+			a.claim = "sub"
+		}
+	}
+
+	return a, nil
+}
+
+// authenticate returns the client which sent the request, or an error if the client could not be authenticated
+func (a *authenticator) authenticate(r *http.Request) (*AuthClient, error) {
+
+	token := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token == "" {
+		token = strings.TrimSpace(bearer)
+	}
+
+	if token != "" {
+		for i, c := range a.clients {
+			for _, key := range c.APIKeys {
+				if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+					return &a.clients[i], nil
+				}
+			}
+		}
+		if a.jwt == nil || strings.Count(token, ".") != 2 {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		claims, err := a.jwt.verify(token)
+		if err != nil {
+			return nil, err
+		}
+		subject, _ := claims[a.claim].(string)
+		for i, c := range a.clients {
+			for _, pattern := range c.Subjects {
+				if ok, _ := path.Match(pattern, subject); ok {
+					return &a.clients[i], nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("token %s '%s' is not allowed", a.claim, subject)
+	}
+
+	if identity := clientIdentity(r); identity != "" {
+		for i, c := range a.clients {
+			for _, cn := range c.CommonNames {
+				if cn == identity {
+					return &a.clients[i], nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("client certificate '%s' is not allowed", identity)
+	}
+
+	return nil, fmt.Errorf("an API key, bearer token or client certificate is required")
+}
+
+type authClientKey struct{}
+
+// authenticated wraps an HTTP handler function so that it is only called for authenticated clients (if --auth is set);
+// the client is available to the handler using authClient
+func authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			next(w, r)
+			return
+		}
+		// failed authentications are rate limited by IP address (before authenticating), so that keys can not be guessed
+		if authFailureLimiter != nil {
+			if ok, retryAfter := authFailureLimiter.check(authFailureKey(r)); !ok {
+				writeRateLimited(w, retryAfter)
+				return
+			}
+		}
+		client, err := auth.authenticate(r)
+		if err != nil {
+			if authFailureLimiter != nil {
+				authFailureLimiter.allow(authFailureKey(r))
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gotmpl"`)
+			writeHttpError(w, HttpError{Reason: ReasonUnauthorized, Message: err.Error()})
+			return
+		}
+		if rec := recorderOf(w); rec != nil {
+			rec.client = client.Name
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), authClientKey{}, client)))
+	}
+}
+
+// authClient returns the authenticated client of the request, or nil if authentication is disabled
+func authClient(r *http.Request) *AuthClient {
+	client, _ := r.Context().Value(authClientKey{}).(*AuthClient)
+	return client
+}
+
+// client returns the client with the given name, or nil if there is none
+func (a *authenticator) client(name string) *AuthClient {
+	for i := range a.clients {
+		if a.clients[i].Name == name {
+			return &a.clients[i]
+		}
+	}
+	return nil
+}
+
+// allowsTemplate returns true if the client may use the named template
+func (c *AuthClient) allowsTemplate(name string) bool {
+	if c == nil || len(c.Templates) == 0 {
+		return true
+	}
+	for _, pattern := range c.Templates {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsInline returns true if the client may send its own templates
+func (c *AuthClient) allowsInline() bool {
+	return c == nil || !c.NamedOnly
+}
+
+// profile returns the function profile for the client's inline and uploaded templates, which is the stricter
+// of the server's profile and the client's profile
+func (c *AuthClient) profile(serverProfile template.Profile) template.Profile {
+	if c == nil || c.Profile == "" || serverProfile == template.ProfileHermetic {
+		return serverProfile
+	}
+	return c.Profile
+}
+
+func templateForbidden(name string) *HttpError {
+	return &HttpError{Reason: ReasonForbidden, Message: fmt.Sprintf("not allowed to use template '%s'", name)}
+}
+
+func inlineForbidden() *HttpError {
+	return &HttpError{Reason: ReasonForbidden, Message: "not allowed to send templates; only named templates can be used"}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+	"github.com/stretchr/testify/assert"
+)
+
+// signJWT returns a JWT with the given claims signed using RS256 or ES256 depending on the key
+func signJWT(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	return signJWTAlg(t, alg, kid, key, claims)
+}
+
+// signJWTAlg returns a JWT with the given claims signed using the hash of alg, whether or not alg matches the key
+func signJWTAlg(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	hash, err := jwtHash(alg)
+	assert.NoError(t, err)
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := hash.New()
+	digest.Write([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest.Sum(nil))
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeTestAuth(t *testing.T) (dir string, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+	}})
+
+	dir = t.TempDir()
+	os.WriteFile(filepath.Join(dir, "jwks.json"), jwks, 0644)
+	os.WriteFile(filepath.Join(dir, "auth.yaml"), []byte(`
+jwt:
+  jwks: jwks.json
+  issuer: https://issuer.example
+  audience: gotmpl
+clients:
+- name: admin
+  apiKeys: [admin-key]
+- name: reports
+  apiKeys: [reports-key]
+  subjects: ["service-*"]
+  templates: ["reports/*"]
+  namedOnly: true
+- name: untrusted
+  apiKeys: [untrusted-key]
+  commonNames: [untrusted.example]
+  profile: hermetic
+`), 0644)
+	return dir, rsaKey, ecKey
+}
+
+func TestAuthenticate(t *testing.T) {
+
+	dir, rsaKey, ecKey := writeTestAuth(t)
+	a, err := loadAuth(filepath.Join(dir, "auth.yaml"))
+	assert.NoError(t, err)
+
+	valid := map[string]interface{}{"sub": "service-a", "iss": "https://issuer.example", "aud": []string{"gotmpl"}, "exp": time.Now().Add(time.Hour).Unix()}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := make(map[string]interface{})
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		client string
+	}{
+		{name: "api key", header: "X-API-Key", value: "admin-key", client: "admin"},
+		{name: "api key as bearer", header: "Authorization", value: "Bearer reports-key", client: "reports"},
+		{name: "wrong api key", header: "X-API-Key", value: "wrong"},
+		{name: "none"},
+		{name: "rsa jwt", header: "Authorization", value: "Bearer " + signJWT(t, "rsa", rsaKey, valid), client: "reports"},
+		{name: "ec jwt", header: "Authorization", value: "Bearer " + signJWT(t, "ec", ecKey, valid), client: "reports"},
+		{name: "wrong key id", header: "Authorization", value: "Bearer " + signJWT(t, "ec", rsaKey, valid)},
+		{name: "expired", header: "Authorization", value: "Bearer " + signJWT(t, "rsa", rsaKey, with("exp", time.Now().Add(-time.Hour).Unix()))},
+		{name: "wrong issuer", header: "Authorization", value: "Bearer " + signJWT(t, "rsa", rsaKey, with("iss", "other"))},
+		{name: "wrong audience", header: "Authorization", value: "Bearer " + signJWT(t, "rsa", rsaKey, with("aud", "other"))},
+		{name: "unknown subject", header: "Authorization", value: "Bearer " + signJWT(t, "rsa", rsaKey, with("sub", "user"))},
+		{name: "no expiry", header: "Authorization", value: "Bearer " + signJWT(t, "rsa", rsaKey, with("exp", nil))},
+		{name: "algorithm of another curve", header: "Authorization", value: "Bearer " + signJWTAlg(t, "ES384", "ec", ecKey, valid)},
+		{name: "algorithm of another key type", header: "Authorization", value: "Bearer " + signJWTAlg(t, "ES256", "rsa", ecKey, valid)},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/gotmpl", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		client, err := a.authenticate(r)
+		if tt.client == "" {
+			assert.Error(t, err, tt.name)
+			continue
+		}
+		if assert.NoError(t, err, tt.name) {
+			assert.Equal(t, tt.client, client.Name, tt.name)
+		}
+	}
+
+	// tokens without exp are only accepted with allowNoExpiry
+	_, err = (&jwtVerifier{keys: a.jwt.keys, allowNoExpiry: true}).verify(signJWT(t, "rsa", rsaKey, with("exp", nil)))
+	assert.NoError(t, err)
+
+	// the algorithm of a key must match its type and curve
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec", "alg": "ES384", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+	}})
+	os.WriteFile(filepath.Join(dir, "mismatch.json"), jwks, 0644)
+	_, err = loadJWKS(filepath.Join(dir, "mismatch.json"))
+	assert.ErrorContains(t, err, "algorithm 'ES384' can not be used with key type 'EC'")
+}
+
+func TestAuthRules(t *testing.T) {
+
+	dir, _, _ := writeTestAuth(t)
+	var err error
+	auth, err = loadAuth(filepath.Join(dir, "auth.yaml"))
+	assert.NoError(t, err)
+	templates = newRegistry()
+	allowUpload = true
+	defer func() {
+		auth = nil
+		templates = newRegistry()
+		allowUpload = false
+	}()
+	templates.put("reports/daily", `{{ .a }}`, sourceUpload)
+	templates.put("other", `{{ .a }}`, sourceUpload)
+
+	tests := []struct {
+		name    string
+		key     string
+		request *http.Request
+		handler http.HandlerFunc
+		status  int
+	}{
+		{name: "no key", request: newJSONRequest("/render/other", `{}`), handler: handleRender, status: http.StatusUnauthorized},
+		{name: "admin named", key: "admin-key", request: newJSONRequest("/render/other", `{"data": {"a": "b"}}`), handler: handleRender, status: http.StatusOK},
+		{name: "admin inline", key: "admin-key", request: newJSONRequest("/gotmpl", `{"template": "{{ now }}"}`), handler: handlePath, status: http.StatusOK},
+		{name: "reports allowed", key: "reports-key", request: newJSONRequest("/render/reports/daily", `{"data": {"a": "b"}}`), handler: handleRender, status: http.StatusOK},
+		{name: "reports not allowed", key: "reports-key", request: newJSONRequest("/render/other", `{"data": {"a": "b"}}`), handler: handleRender, status: http.StatusForbidden},
+		{name: "reports get not allowed", key: "reports-key", request: httptest.NewRequest(http.MethodGet, "/templates/other", nil), handler: handleTemplates, status: http.StatusForbidden},
+		{name: "reports inline", key: "reports-key", request: newJSONRequest("/gotmpl", `{"template": "{{ .a }}"}`), handler: handlePath, status: http.StatusForbidden},
+		{name: "reports lint", key: "reports-key", request: newFormRequest("/lint", map[string]string{"template": "{{ .a }}"}), handler: handleLint, status: http.StatusForbidden},
+		{name: "reports upload", key: "reports-key", request: httptest.NewRequest(http.MethodPut, "/templates/reports/new", strings.NewReader(`{{ .a }}`)), handler: handleTemplates, status: http.StatusForbidden},
+		{name: "untrusted upload", key: "untrusted-key", request: httptest.NewRequest(http.MethodPut, "/templates/new", strings.NewReader(`{{ .a }}`)), handler: handleTemplates, status: http.StatusCreated},
+		{name: "untrusted upload non-hermetic", key: "untrusted-key", request: httptest.NewRequest(http.MethodPut, "/templates/now", strings.NewReader(`{{ now }}`)), handler: handleTemplates, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		if tt.key != "" {
+			tt.request.Header.Set("X-API-Key", tt.key)
+		}
+		w := httptest.NewRecorder()
+		authenticated(tt.handler)(w, tt.request)
+		assert.Equal(t, tt.status, w.Code, tt.name)
+	}
+
+	// the template list only includes allowed templates
+	r := httptest.NewRequest(http.MethodGet, "/templates", nil)
+	r.Header.Set("X-API-Key", "reports-key")
+	w := httptest.NewRecorder()
+	authenticated(handleTemplates)(w, r)
+	var list TemplateListResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Templates, 1)
+	assert.Equal(t, "reports/daily", list.Templates[0].Name)
+
+	// the profile of a client is used for its inline templates, but it can not be looser than the server's profile
+	client := &auth.clients[2]
+	assert.Equal(t, "hermetic", string(client.profile(defaultProfile)))
+	assert.Equal(t, defaultProfile, (*AuthClient)(nil).profile(defaultProfile))
+	assert.Equal(t, template.ProfileHermetic, (&AuthClient{Profile: template.ProfileDefault}).profile(template.ProfileHermetic))
+}
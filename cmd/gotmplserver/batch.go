@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+var (
+	// batchWorkers is the number of items of a batch which are rendered at the same time
+	batchWorkers = 4
+	// maxBatchItems is the maximum number of items in a batch (0 means no limit)
+	maxBatchItems = 1000
+	// batchTimeout is the time a batch has to render all of its items, which is shorter than --write-timeout
+	// so that the results can still be written (0 means no limit)
+	batchTimeout time.Duration
+)
+
+// BatchRequest is the body of POST /render/batch, which is either one template (Template or Name)
+// rendered with each element of Data, or a list of Items which each have their own template and data
+type BatchRequest struct {
+	// Template is the template text to render with each element of Data
+	Template string `json:"template,omitempty"`
+	// Name is the name of a template from the registry to render with each element of Data
+	Name string `json:"name,omitempty"`
+	// Data is a list of data (each the same as RenderRequest.Data)
+	Data []json.RawMessage `json:"data,omitempty"`
+	// Items are rendered independently of each other
+	Items []BatchItem `json:"items,omitempty"`
+	// Options are used for all items, unless an item has its own options
+	Options RenderOptions `json:"options"`
+}
+
+// BatchItem is one template (Template or Name) and its data in a BatchRequest
+type BatchItem struct {
+	Template string          `json:"template,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Data     json.RawMessage `json:"data"`
+	Options  *RenderOptions  `json:"options,omitempty"`
+}
+
+// BatchResponse is the response of POST /render/batch; it has one result per item in the same order as the request
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the result of one item; Status is the status code the item would have had as a single request
+type BatchResult struct {
+	Status      int        `json:"status"`
+	Output      string     `json:"output"`
+	ContentType string     `json:"contentType,omitempty"`
+	MissingKeys []string   `json:"missingKeys,omitempty"`
+	Error       *HttpError `json:"error,omitempty"`
+}
+
+// batchJob is a compiled template and the request to render it with
+type batchJob struct {
+	req  RenderRequest
+	tmpl *template.Template
+	err  *HttpError
+}
+
+// handleBatch handles POST /render/batch which renders many templates and/or data in one request using a bounded number of workers.
+// The workers wait for free render slots, which are shared with all other requests, and an item which has not been rendered
+// before batchTimeout fails with RenderTimeout.
+func handleBatch(w http.ResponseWriter, r *http.Request) {
+
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		w.Header().Add("Allow", "POST")
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: "batch requests must be sent as application/json"})
+		return
+	}
+	var batch BatchRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&batch); err != nil {
+		writeRequestError(w, fmt.Errorf("could not read JSON request body: %w", err))
+		return
+	}
+
+	items, httpErr := batchJobs(authClient(r), batch)
+	if httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
+
+	ctx := withRenderWait(r.Context())
+	if batchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, batchTimeout)
+		defer cancel()
+	}
+
+	results := make([]BatchResult, len(items))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < batchWorkers && i < len(items); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = renderBatchJob(ctx, w, items[index])
+			}
+		}()
+	}
+	for index := range items {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	writeJson(w, http.StatusOK, BatchResponse{Results: results})
+}
+
+// batchJobs validates the batch request and compiles the template(s) of each item. An error with a single template
+// (Template or Name) fails the whole batch, while an error with the template of an item only fails that item.
+func batchJobs(client *AuthClient, batch BatchRequest) ([]batchJob, *HttpError) {
+
+	single := batch.Template != "" || batch.Name != ""
+	switch {
+	case single && len(batch.Items) > 0:
+		return nil, &HttpError{Reason: ReasonRequestError, Message: "a batch must have either template or name with data, or items, but not both"}
+	case batch.Template != "" && batch.Name != "":
+		return nil, &HttpError{Reason: ReasonRequestError, Message: "a batch must have either template or name, but not both"}
+	case !single && len(batch.Data) > 0:
+		return nil, &HttpError{Reason: ReasonRequestError, Message: "data can only be sent with a template or name; use items for different templates"}
+	}
+	count := len(batch.Items) + len(batch.Data)
+	if count == 0 {
+		return nil, &HttpError{Reason: ReasonRequestError, Message: "a batch must have at least one item"}
+	}
+	if maxBatchItems > 0 && count > maxBatchItems {
+		return nil, &HttpError{Reason: ReasonRequestTooLarge, Message: fmt.Sprintf("batch has %d items which is more than the limit of %d", count, maxBatchItems)}
+	}
+
+	items := make([]batchJob, 0, count)
+	if single {
+		req := RenderRequest{Template: batch.Template, Options: batch.Options}
+		tmpl, httpErr := compileBatchTemplate(client, batch.Name, &req)
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		for _, data := range batch.Data {
+			req.Data = data
+			items = append(items, batchJob{req: req, tmpl: tmpl})
+		}
+		return items, nil
+	}
+
+	for _, item := range batch.Items {
+		req := RenderRequest{Template: item.Template, Data: item.Data, Options: batch.Options}
+		if item.Options != nil {
+			req.Options = *item.Options
+		}
+		var tmpl *template.Template
+		var httpErr *HttpError
+		if item.Template != "" && item.Name != "" {
+			httpErr = &HttpError{Reason: ReasonRequestError, Message: "an item must have either template or name, but not both"}
+		} else {
+			tmpl, httpErr = compileBatchTemplate(client, item.Name, &req)
+		}
+		items = append(items, batchJob{req: req, tmpl: tmpl, err: httpErr})
+	}
+	return items, nil
+}
+
+// compileBatchTemplate returns the named template if name is set, otherwise it compiles the template of the request
+func compileBatchTemplate(client *AuthClient, name string, req *RenderRequest) (*template.Template, *HttpError) {
+	if name == "" {
+		return compileTemplate(client, *req)
+	}
+	rt, httpErr := lookupTemplate(client, name)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	return rt.withOptions(&req.Options)
+}
+
+func renderBatchJob(ctx context.Context, w http.ResponseWriter, job batchJob) BatchResult {
+	httpErr := job.err
+	var result rendered
+	if httpErr == nil {
+		result, httpErr = render(ctx, w, job.req, job.tmpl)
+	}
+	if httpErr != nil {
+		return BatchResult{Status: reasonStatus[httpErr.Reason], Error: httpErr}
+	}
+	return BatchResult{Status: http.StatusOK, Output: string(result.output), ContentType: result.contentType, MissingKeys: result.missingKeys}
+}
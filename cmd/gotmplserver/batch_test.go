@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleBatch(t *testing.T) {
+
+	templates = newRegistry()
+	defer func() { templates = newRegistry() }()
+	templates.put("greeting.json", `{"greeting": "Hi {{ .name }}"}`, sourceUpload)
+
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		results []BatchResult
+		reason  string
+	}{{
+		name:   "one template with many data",
+		body:   `{"template": "Hi {{ .name }}", "data": [{"name": "a"}, {"name": "b"}, {}]}`,
+		status: http.StatusOK,
+		results: []BatchResult{
+			{Status: 200, Output: "Hi a", ContentType: "text/plain; charset=utf-8"},
+			{Status: 200, Output: "Hi b", ContentType: "text/plain; charset=utf-8"},
+			{Status: 422, Error: &HttpError{Reason: ReasonTemplateRenderingError, Message: `template: gotmpl:1:6: executing "gotmpl" at <.name>: map has no entry for key "name"`, Line: 1, Column: 7}},
+		},
+	}, {
+		name:   "named template with many data",
+		body:   `{"name": "greeting.json", "data": [{"name": "a"}, "name: b"]}`,
+		status: http.StatusOK,
+		results: []BatchResult{
+			{Status: 200, Output: `{"greeting": "Hi a"}`, ContentType: "application/json"},
+			{Status: 200, Output: `{"greeting": "Hi b"}`, ContentType: "application/json"},
+		},
+	}, {
+		name: "items",
+		body: `{"items": [
+			{"template": "{{ .a }}", "data": {"a": 1}},
+			{"name": "greeting.json", "data": {"name": "c"}},
+			{"name": "missing", "data": {}},
+			{"template": "{{ .a ", "data": {}},
+			{"template": "{{ .b }}", "data": {}, "options": {"missingKey": "zero"}}
+		]}`,
+		status: http.StatusOK,
+		results: []BatchResult{
+			{Status: 200, Output: "1", ContentType: "text/plain; charset=utf-8"},
+			{Status: 200, Output: `{"greeting": "Hi c"}`, ContentType: "application/json"},
+			{Status: 404, Error: &HttpError{Reason: ReasonTemplateNotFound, Message: "template 'missing' not found"}},
+			{Status: 422, Error: &HttpError{Reason: ReasonTemplateError, Message: "template: gotmpl:1: unclosed action", Line: 1}},
+			{Status: 200, Output: "<no value>", ContentType: "text/plain; charset=utf-8"},
+		},
+	}, {
+		name:   "invalid single template fails the batch",
+		body:   `{"template": "{{ .a ", "data": [{}]}`,
+		status: http.StatusUnprocessableEntity,
+		reason: ReasonTemplateError,
+	}, {
+		name:   "template and items",
+		body:   `{"template": "{{ .a }}", "items": [{"template": "{{ .a }}"}]}`,
+		status: http.StatusBadRequest,
+		reason: ReasonRequestError,
+	}, {
+		name:   "empty",
+		body:   `{"template": "{{ .a }}"}`,
+		status: http.StatusBadRequest,
+		reason: ReasonRequestError,
+	}}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleBatch(w, newJSONRequest("/render/batch", tt.body))
+		assert.Equal(t, tt.status, w.Code, tt.name)
+		if tt.reason != "" {
+			var response HttpErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &response)
+			assert.Equal(t, tt.reason, response.Error.Reason, tt.name)
+			continue
+		}
+		var response BatchResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response), tt.name)
+		assert.Equal(t, tt.results, response.Results, tt.name)
+	}
+}
+
+func TestHandleBatchLimit(t *testing.T) {
+
+	maxBatchItems = 2
+	defer func() { maxBatchItems = 1000 }()
+
+	w := httptest.NewRecorder()
+	handleBatch(w, newJSONRequest("/render/batch", `{"template": "{{ .a }}", "data": [{}, {}, {}]}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestHandleBatchRenderSlots(t *testing.T) {
+
+	renderSlots = make(chan struct{}, 1)
+	batchTimeout = time.Second
+	defer func() {
+		renderSlots = nil
+		batchTimeout = 0
+	}()
+
+	// the items of a batch wait for a free render slot instead of failing with ServerBusy
+	renderSlots <- struct{}{}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-renderSlots
+	}()
+	w := httptest.NewRecorder()
+	handleBatch(w, newJSONRequest("/render/batch", `{"template": "{{ .a }}", "data": [{"a": 1}, {"a": 2}, {"a": 3}]}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response BatchResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	for _, result := range response.Results {
+		assert.Equal(t, http.StatusOK, result.Status)
+	}
+
+	// but only until the batch times out
+	batchTimeout = 20 * time.Millisecond
+	renderSlots <- struct{}{}
+	defer func() { <-renderSlots }()
+	w = httptest.NewRecorder()
+	handleBatch(w, newJSONRequest("/render/batch", `{"template": "{{ .a }}", "data": [{"a": 1}, {"a": 2}]}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	for _, result := range response.Results {
+		assert.Equal(t, http.StatusGatewayTimeout, result.Status)
+		assert.Equal(t, ReasonRenderTimeout, result.Error.Reason)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docopt/docopt-go"
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+// renderCache caches the output of renders; caching is disabled if it is nil
+var renderCache *resultCache
+
+// resultCache is an in-memory least recently used cache of render results, limited by the total size of
+// the results and by how long each result is kept
+type resultCache struct {
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List // most recently used first
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	result  rendered
+	size    int64
+	expires time.Time
+}
+
+func newResultCache(maxBytes int64, ttl time.Duration) *resultCache {
+	return &resultCache{maxBytes: maxBytes, ttl: ttl, entries: make(map[string]*list.Element), lru: list.New(), now: time.Now}
+}
+
+// parseCache returns the render cache of the options, or nil if --cache-max-bytes is 0
+func parseCache(opts docopt.Opts) (*resultCache, error) {
+	value, _ := opts.String("--cache-max-bytes")
+	maxBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxBytes < 0 {
+		return nil, fmt.Errorf("invalid --cache-max-bytes '%s'", value)
+	}
+	value, _ = opts.String("--cache-ttl")
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid --cache-ttl '%s' (must be a duration greater than 0)", value)
+	}
+	if maxBytes == 0 {
+		return nil, nil
+	}
+	return newResultCache(maxBytes, ttl), nil
+}
+
+// resultKey returns a key which identifies the result of rendering tmpl with data and the options which affect
+// the result, or false if the template calls functions which can give a different result for the same data.
+// The data is hashed as JSON (where object keys are sorted) so that the same data in any format or order has the same key.
+func resultKey(tmpl *template.Template, o RenderOptions, data map[string]interface{}) (string, bool) {
+	if !tmpl.Hermetic() {
+		return "", false
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	for _, s := range []string{tmpl.Hash(), o.OutputType, strconv.FormatBool(o.ValidateOutput), strconv.FormatBool(o.ReportMissing)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(dataJSON)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// resultETag returns the entity tag of the result with the key
+func resultETag(key string) string {
+	return `"` + key[:32] + `"`
+}
+
+// etagMatches returns true if the If-None-Match header value is * or lists the entity tag (compared weakly)
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the cached result of the key if it has not expired
+func (c *resultCache) get(key string) (rendered, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return rendered{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.now().After(entry.expires) {
+		c.remove(element)
+		return rendered{}, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+// put caches the result of the key, removing the least recently used results if the cache is full;
+// a result which is larger than the whole cache is not cached
+func (c *resultCache) put(key string, result rendered) {
+	size := int64(len(key) + len(result.output) + len(result.contentType))
+	for _, k := range result.missingKeys {
+		size += int64(len(k))
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	for c.size+size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, result: result, size: size, expires: c.now().Add(c.ttl)})
+	c.size += size
+}
+
+func (c *resultCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// stats returns the number of cached results and their total size
+func (c *resultCache) stats() (entries int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCache(t *testing.T) {
+
+	now := time.Now()
+	c := newResultCache(100, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.put("a", rendered{output: []byte(strings.Repeat("a", 40))})
+	c.put("b", rendered{output: []byte(strings.Repeat("b", 40))})
+	_, ok := c.get("a")
+	assert.True(t, ok)
+
+	// b is the least recently used so it is removed to make room for c
+	c.put("c", rendered{output: []byte(strings.Repeat("c", 40))})
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	entries, size := c.stats()
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, int64(82), size)
+
+	// a result larger than the cache is not cached
+	c.put("d", rendered{output: []byte(strings.Repeat("d", 100))})
+	_, ok = c.get("d")
+	assert.False(t, ok)
+
+	// results expire after the TTL
+	now = now.Add(2 * time.Minute)
+	_, ok = c.get("a")
+	assert.False(t, ok)
+	entries, size = c.stats()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, int64(41), size)
+}
+
+func TestResultKey(t *testing.T) {
+
+	engine := newEngine()
+	tmpl, _ := engine.Compile(`{{ .a }} {{ .b }}`)
+	key, ok := resultKey(tmpl, RenderOptions{}, map[string]interface{}{"a": 1, "b": "x"})
+	assert.True(t, ok)
+
+	// the same data from another format, or in another order, has the same key
+	req := RenderRequest{Data: []byte(`"b: x\na: 1"`)}
+	data, _ := req.data()
+	same, _ := resultKey(tmpl, RenderOptions{}, data)
+	assert.Equal(t, key, same)
+
+	other, _ := resultKey(tmpl, RenderOptions{}, map[string]interface{}{"a": 2, "b": "x"})
+	assert.NotEqual(t, key, other)
+	other, _ = resultKey(tmpl, RenderOptions{OutputType: "json"}, data)
+	assert.NotEqual(t, key, other)
+
+	tmpl, _ = engine.Compile(`{{ now }}`)
+	_, ok = resultKey(tmpl, RenderOptions{}, data)
+	assert.False(t, ok)
+}
+
+func TestHandlePathCache(t *testing.T) {
+
+	renderCache = newResultCache(1<<20, time.Minute)
+	metrics = newServerMetrics()
+	defer func() {
+		renderCache = nil
+		metrics = newServerMetrics()
+	}()
+
+	post := func(body string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := newJSONRequest("/gotmpl", body)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		handlePath(w, r)
+		return w
+	}
+
+	w := post(`{"template": "{{ .a }}", "data": {"a": "b"}}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "miss", w.Header().Get("X-Gotmpl-Cache"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	w = post(`{"template": "{{ .a }}", "data": "a: b", "options": {"format": "yaml"}}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "b", w.Body.String())
+	assert.Equal(t, "hit", w.Header().Get("X-Gotmpl-Cache"))
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = post(`{"template": "{{ .a }}", "data": {"a": "b"}}`, `"other", `+etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// templates using time or random functions are not cached
+	w = post(`{"template": "{{ .a }} {{ randAlpha 5 }}", "data": {"a": "b"}}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("X-Gotmpl-Cache"))
+
+	// errors are not cached
+	post(`{"template": "{{ .missing }}", "data": {}}`, "")
+	w = post(`{"template": "{{ .missing }}", "data": {}}`, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `gotmpl_render_cache_requests_total{result="hit"} 2`)
+	assert.Contains(t, w.Body.String(), `gotmpl_render_cache_requests_total{result="miss"} 3`)
+	assert.Contains(t, w.Body.String(), `gotmpl_render_cache_requests_total{result="uncacheable"} 1`)
+	assert.Contains(t, w.Body.String(), "gotmpl_render_cache_entries 1\n")
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"a"`, `"a"`))
+	assert.True(t, etagMatches(`"b", W/"a"`, `"a"`))
+	assert.True(t, etagMatches(`*`, `"a"`))
+	assert.False(t, etagMatches(``, `"a"`))
+	assert.False(t, etagMatches(`"b"`, `"a"`))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docopt/docopt-go"
+	"sigs.k8s.io/yaml"
+)
+
+// envPrefix is the prefix of the environment variables which set options, e.g. GOTMPL_MAX_DATA_BYTES for --max-data-bytes
+const envPrefix = "GOTMPL_"
+
+// defaultPattern matches the defaults in the usage
+var defaultPattern = regexp.MustCompile(`\s*\[default: [^\]]*\]`)
+
+// envName returns the name of the environment variable of an option, e.g. GOTMPL_MAX_DATA_BYTES for --max-data-bytes
+func envName(option string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(option, "--"), "-", "_"))
+}
+
+// applyConfig sets each option which was not given in args from its GOTMPL_* environment variable or, if that
+// is not set either, from the config file (--config or GOTMPL_CONFIG). The precedence is therefore: command line,
+// environment, config file and then the default. It returns the name of the config file, if any, and the GOTMPL_*
+// environment variables which are not options (which may be misspelled, or used by something else, e.g. a build script).
+func applyConfig(opts docopt.Opts, usage string, args []string, environ []string) (string, []string, error) {
+
+	// parse the arguments again without the defaults to find which options were given on the command line
+	parser := &docopt.Parser{HelpHandler: docopt.NoHelpHandler, SkipHelpFlags: true}
+	given, err := parser.ParseArgs(defaultPattern.ReplaceAllString(usage, ""), args, "")
+	if err != nil {
+		return "", nil, err
+	}
+	isGiven := func(option string) bool {
+		value, ok := given[option]
+		return ok && value != nil && value != false
+	}
+
+	env := map[string]string{}
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, envPrefix) {
+			env[name] = value
+		}
+	}
+	// GOTMPL_* environment variables which are not options are returned so that a misspelled one is not silently ignored;
+	// --help and --version can only be given on the command line, so e.g. GOTMPL_VERSION is not an option either
+	unknown := []string{}
+	for name := range env {
+		option := "--" + strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(name, envPrefix), "_", "-"))
+		if _, ok := opts[option]; !ok || option == "--help" || option == "--version" {
+			unknown = append(unknown, name)
+			delete(env, name)
+		}
+	}
+	sort.Strings(unknown)
+
+	configFile, _ := opts.String("--config")
+	if !isGiven("--config") {
+		configFile = env[envName("--config")]
+	}
+	config := map[string]string{}
+	if configFile != "" {
+		if config, err = readConfig(configFile); err != nil {
+			return "", nil, err
+		}
+		for key := range config {
+			if _, ok := opts["--"+key]; !ok || key == "config" || key == "help" || key == "version" {
+				return "", nil, fmt.Errorf("unknown option '%s' in config file %s", key, configFile)
+			}
+		}
+	}
+
+	for option, current := range opts {
+		if !strings.HasPrefix(option, "--") || isGiven(option) {
+			continue
+		}
+		value, ok := env[envName(option)]
+		source := envName(option)
+		if !ok {
+			value, ok = config[strings.TrimPrefix(option, "--")]
+			source = configFile
+		}
+		if !ok {
+			continue
+		}
+		if _, isBool := current.(bool); isBool {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid %s in %s: must be true or false", option, source)
+			}
+			opts[option] = b
+			continue
+		}
+		opts[option] = value
+	}
+	return configFile, unknown, nil
+}
+
+// readConfig reads a YAML (.yaml, .yml or .json) or TOML (.toml) config file whose keys are the names of options
+// without "--", e.g. "max-data-bytes", and returns the values as strings; lists are joined with commas
+func readConfig(file string) (map[string]string, error) {
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(b, &values)
+	default:
+		return nil, fmt.Errorf("config file %s must be YAML (.yaml, .yml or .json) or TOML (.toml)", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file %s: %w", file, err)
+	}
+
+	config := map[string]string{}
+	for key, value := range values {
+		// a number loses its digits (YAML reads 0660 as the octal number 432), so the socket mode must be a string
+		if _, isString := value.(string); key == "socket-mode" && !isString {
+			return nil, fmt.Errorf("invalid 'socket-mode' in config file %s: must be a string, e.g. \"0660\"", file)
+		}
+		switch v := value.(type) {
+		case []interface{}:
+			elements := make([]string, 0, len(v))
+			for _, element := range v {
+				elements = append(elements, fmt.Sprint(element))
+			}
+			config[key] = strings.Join(elements, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("invalid '%s' in config file %s: must be a value or a list", key, file)
+		case float64:
+			// numbers from YAML are float64, but must not be formatted using an exponent
+			config[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			config[key] = fmt.Sprint(v)
+		}
+	}
+	return config, nil
+}
+
+// splitList splits a comma separated list and removes empty elements
+func splitList(list string) []string {
+	elements := []string{}
+	for _, element := range strings.Split(list, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docopt/docopt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyConfig(t *testing.T) {
+
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "config.yaml")
+	os.WriteFile(yamlFile, []byte("port: 8080\npath: /file\nmax-data-bytes: 2000000\nallow-upload: true\ntemplates: [a, b]\ntimezone: UTC\n"), 0644)
+	tomlFile := filepath.Join(dir, "config.toml")
+	os.WriteFile(tomlFile, []byte("port = 8081\nredact-data = true\nread-timeout = \"5s\"\n"), 0644)
+
+	parse := func(args []string, environ []string) (docopt.Opts, string, error) {
+		opts, err := docopt.ParseArgs(usage, args, "")
+		assert.NoError(t, err)
+		file, _, err := applyConfig(opts, usage, args, environ)
+		return opts, file, err
+	}
+
+	// command line, then environment, then config file, then default
+	opts, file, err := parse([]string{"--config", yamlFile, "--port", "9000"}, []string{"GOTMPL_PATH=/env", "HOME=/root"})
+	assert.NoError(t, err)
+	assert.Equal(t, yamlFile, file)
+	assert.Equal(t, "9000", opts["--port"])
+	assert.Equal(t, "/env", opts["--path"])
+	assert.Equal(t, "2000000", opts["--max-data-bytes"])
+	assert.Equal(t, true, opts["--allow-upload"])
+	assert.Equal(t, "a,b", opts["--templates"])
+	assert.Equal(t, "UTC", opts["--timezone"])
+	assert.Equal(t, "error", opts["--missingkey"])
+
+	// the config file can also be set using GOTMPL_CONFIG
+	opts, file, err = parse([]string{}, []string{"GOTMPL_CONFIG=" + tomlFile, "GOTMPL_READ_TIMEOUT=10s"})
+	assert.NoError(t, err)
+	assert.Equal(t, tomlFile, file)
+	assert.Equal(t, "8081", opts["--port"])
+	assert.Equal(t, true, opts["--redact-data"])
+	assert.Equal(t, "10s", opts["--read-timeout"])
+
+	// no config at all keeps the defaults
+	opts, file, err = parse([]string{}, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", file)
+	assert.Equal(t, "10000", opts["--port"])
+	assert.Equal(t, false, opts["--allow-upload"])
+
+	// GOTMPL_* environment variables which are not options (e.g. of a build script) are returned rather than failing
+	opts, _ = docopt.ParseArgs(usage, []string{}, "")
+	_, unknown, err := applyConfig(opts, usage, []string{}, []string{"GOTMPL_VERSION=v1", "GOTMPL_PROT=8080", "GOTMPL_PORT=8080"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GOTMPL_PROT", "GOTMPL_VERSION"}, unknown)
+
+	// a socket mode must be a string, since YAML reads 0660 as the number 432
+	yamlSocket := filepath.Join(dir, "socket.yaml")
+	os.WriteFile(yamlSocket, []byte("socket-mode: \"0600\"\n"), 0644)
+	opts, _, err = parse([]string{"--config", yamlSocket}, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "0600", opts["--socket-mode"])
+	os.WriteFile(filepath.Join(dir, "socket-number.yaml"), []byte("socket-mode: 0660\n"), 0644)
+
+	os.WriteFile(filepath.Join(dir, "unknown.yaml"), []byte("prot: 8080\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "nested.yaml"), []byte("auth:\n  clients: []\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "config.ini"), []byte("port=8080\n"), 0644)
+	for _, tt := range []struct {
+		args    []string
+		environ []string
+		err     string
+	}{
+		{[]string{"--config", filepath.Join(dir, "socket-number.yaml")}, []string{}, "invalid 'socket-mode'"},
+		{[]string{}, []string{"GOTMPL_ALLOW_UPLOAD=maybe"}, "invalid --allow-upload in GOTMPL_ALLOW_UPLOAD"},
+		{[]string{"--config", filepath.Join(dir, "unknown.yaml")}, []string{}, "unknown option 'prot'"},
+		{[]string{"--config", filepath.Join(dir, "nested.yaml")}, []string{}, "invalid 'auth'"},
+		{[]string{"--config", filepath.Join(dir, "config.ini")}, []string{}, "must be YAML"},
+		{[]string{"--config", filepath.Join(dir, "missing.yaml")}, []string{}, "no such file"},
+	} {
+		_, _, err := parse(tt.args, tt.environ)
+		assert.ErrorContains(t, err, tt.err, tt.args, tt.environ)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docopt/docopt-go"
+)
+
+// corsExposedHeaders are the response headers which browser clients can read
+var corsExposedHeaders = []string{"ETag", "Retry-After", "X-Request-Id", "X-Gotmpl-Missing-Keys", "X-Gotmpl-Template-Version", "X-Gotmpl-Template-ETag", "X-Gotmpl-Cache"}
+
+// cors is the CORS policy for browser clients; CORS is disabled if it is nil
+var cors *corsPolicy
+
+// corsPolicy is which origins can call the API from a browser, and which methods and request headers they can use
+type corsPolicy struct {
+	origins []string // patterns, e.g. https://*.example.com, or * for any origin
+	methods []string
+	headers []string
+	maxAge  time.Duration
+}
+
+// parseCORS returns the CORS policy of the options, or nil if --cors-origins is not set
+func parseCORS(opts docopt.Opts) (*corsPolicy, error) {
+	origins, _ := opts.String("--cors-origins")
+	methods, _ := opts.String("--cors-methods")
+	headers, _ := opts.String("--cors-headers")
+	maxAge, _ := opts.String("--cors-max-age")
+	if origins == "" {
+		return nil, nil
+	}
+	policy := &corsPolicy{origins: splitList(origins), methods: splitList(methods), headers: splitList(headers)}
+	for _, pattern := range policy.origins {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --cors-origins pattern '%s': %w", pattern, err)
+		}
+	}
+	for i, method := range policy.methods {
+		policy.methods[i] = strings.ToUpper(method)
+	}
+	var err error
+	if policy.maxAge, err = time.ParseDuration(maxAge); err != nil {
+		return nil, fmt.Errorf("invalid --cors-max-age: %w", err)
+	}
+	return policy, nil
+}
+
+// allowsOrigin returns true if the origin matches one of the allowed origins
+func (c *corsPolicy) allowsOrigin(origin string) bool {
+	for _, pattern := range c.origins {
+		// * matches any origin, even though path.Match would not match the slashes of the scheme
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsMethod returns true if the method is allowed
+func (c *corsPolicy) allowsMethod(method string) bool {
+	for _, m := range c.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders returns true if all of the comma separated request headers are allowed
+func (c *corsPolicy) allowsHeaders(headers string) bool {
+next:
+	for _, header := range splitList(headers) {
+		for _, h := range c.headers {
+			if strings.EqualFold(h, header) {
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// withCORS wraps an HTTP handler so that requests from allowed origins get CORS headers; preflight requests
+// (OPTIONS with Access-Control-Request-Method) are answered here since the API endpoints only allow their own methods
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if cors == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestMethod != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if !cors.allowsOrigin(origin) || !cors.allowsMethod(requestMethod) || !cors.allowsHeaders(requestHeaders) {
+				writeHttpError(w, HttpError{Reason: ReasonForbidden, Message: fmt.Sprintf("cross-origin %s request from '%s' is not allowed", requestMethod, origin)})
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(cors.methods, ", "))
+			if requestHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.headers, ", "))
+			}
+			if cors.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cors.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if cors.allowsOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
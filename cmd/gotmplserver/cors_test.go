@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docopt/docopt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCORS(t *testing.T) {
+
+	opts := docopt.Opts{"--cors-origins": "", "--cors-methods": "get, post", "--cors-headers": "Content-Type", "--cors-max-age": "1m"}
+	policy, err := parseCORS(opts)
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+
+	opts["--cors-origins"] = "https://a.example.com,https://*.example.org"
+	policy, err = parseCORS(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://a.example.com", "https://*.example.org"}, policy.origins)
+	assert.Equal(t, []string{"GET", "POST"}, policy.methods)
+
+	opts["--cors-origins"] = "https://[a"
+	_, err = parseCORS(opts)
+	assert.Error(t, err)
+}
+
+func TestWithCORS(t *testing.T) {
+
+	cors = &corsPolicy{
+		origins: []string{"https://*.example.com"},
+		methods: []string{"GET", "POST"},
+		headers: []string{"Content-Type", "X-API-Key"},
+		maxAge:  10 * time.Minute,
+	}
+	defer func() { cors = nil }()
+	handler := withCORS(newMux("/gotmpl"))
+
+	request := func(method, origin, requestMethod, requestHeaders string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/gotmpl", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", requestMethod)
+		}
+		if requestHeaders != "" {
+			r.Header.Set("Access-Control-Request-Headers", requestHeaders)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name           string
+		response       *httptest.ResponseRecorder
+		status         int
+		allowOrigin    string
+		allowMethods   string
+		allowHeaders   string
+		exposesHeaders bool
+	}{{
+		name:         "preflight",
+		response:     request(http.MethodOptions, "https://app.example.com", "POST", "content-type, x-api-key"),
+		status:       http.StatusNoContent,
+		allowOrigin:  "https://app.example.com",
+		allowMethods: "GET, POST",
+		allowHeaders: "Content-Type, X-API-Key",
+	}, {
+		name:     "preflight from another origin",
+		response: request(http.MethodOptions, "https://example.org", "POST", ""),
+		status:   http.StatusForbidden,
+	}, {
+		name:     "preflight for a method which is not allowed",
+		response: request(http.MethodOptions, "https://app.example.com", "DELETE", ""),
+		status:   http.StatusForbidden,
+	}, {
+		name:     "preflight for a header which is not allowed",
+		response: request(http.MethodOptions, "https://app.example.com", "POST", "X-Other"),
+		status:   http.StatusForbidden,
+	}, {
+		name:     "OPTIONS without preflight is passed to the handler",
+		response: request(http.MethodOptions, "", "", ""),
+		status:   http.StatusMethodNotAllowed,
+	}, {
+		name:           "request",
+		response:       request(http.MethodPost, "https://app.example.com", "", ""),
+		status:         http.StatusOK,
+		allowOrigin:    "https://app.example.com",
+		exposesHeaders: true,
+	}, {
+		name:     "request from another origin",
+		response: request(http.MethodPost, "https://example.org", "", ""),
+		status:   http.StatusOK,
+	}, {
+		name:     "same-origin request",
+		response: request(http.MethodPost, "", "", ""),
+		status:   http.StatusOK,
+	}}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.status, tt.response.Code, tt.name)
+		assert.Equal(t, tt.allowOrigin, tt.response.Header().Get("Access-Control-Allow-Origin"), tt.name)
+		assert.Equal(t, tt.allowMethods, tt.response.Header().Get("Access-Control-Allow-Methods"), tt.name)
+		assert.Equal(t, tt.allowHeaders, tt.response.Header().Get("Access-Control-Allow-Headers"), tt.name)
+		assert.Equal(t, tt.exposesHeaders, tt.response.Header().Get("Access-Control-Expose-Headers") != "", tt.name)
+	}
+	assert.Equal(t, "600", tests[0].response.Header().Get("Access-Control-Max-Age"))
+
+	// any origin
+	cors.origins = []string{"*"}
+	assert.Equal(t, "https://example.org", request(http.MethodOptions, "https://example.org", "GET", "").Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/dataformat"
+	"github.com/joshuagrisham-karolinska/gotmpl/schema"
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+// Reasons of the errors returned by the server (HttpError.Reason); the status code of each is given by reasonStatus
+const (
+	// ReasonRequestError means the request could not be read, e.g. an invalid JSON body or a missing template
+	ReasonRequestError = "RequestError"
+	// ReasonInvalidOption means an option of the request is not valid, e.g. an unknown missing key policy
+	ReasonInvalidOption = "InvalidOption"
+	// ReasonDataUnmarshallingError means the data could not be parsed in its format; Line and Column are set if known
+	ReasonDataUnmarshallingError = "DataUnmarshallingError"
+	// ReasonSchemaError means the requested JSON Schema does not exist or is not valid
+	ReasonSchemaError = "SchemaError"
+	// ReasonUnauthorized means the client could not be authenticated
+	ReasonUnauthorized = "Unauthorized"
+	// ReasonForbidden means the client is not allowed to use the template or endpoint
+	ReasonForbidden = "Forbidden"
+	// ReasonUploadNotAllowed means template uploads are not enabled
+	ReasonUploadNotAllowed = "UploadNotAllowed"
+	// ReasonTemplateNotFound means there is no named template with the requested name
+	ReasonTemplateNotFound = "TemplateNotFound"
+	// ReasonJobNotFound means there is no job with the requested id, or it has expired
+	ReasonJobNotFound = "JobNotFound"
+	// ReasonJobNotFinished means the result of a job was requested before the job has finished
+	ReasonJobNotFinished = "JobNotFinished"
+	// ReasonPreconditionFailed means the template did not match If-Match
+	ReasonPreconditionFailed = "PreconditionFailed"
+	// ReasonRequestTooLarge means the request body is larger than --max-body-bytes
+	ReasonRequestTooLarge = "RequestTooLarge"
+	// ReasonTemplateTooLarge means the template is larger than --max-template-bytes
+	ReasonTemplateTooLarge = "TemplateTooLarge"
+	// ReasonDataTooLarge means the data is larger than --max-data-bytes
+	ReasonDataTooLarge = "DataTooLarge"
+	// ReasonTemplateError means the template could not be parsed; Line is set if known
+	ReasonTemplateError = "TemplateError"
+	// ReasonDataValidationError means the data is not valid according to the JSON Schema; Violations has the details
+	ReasonDataValidationError = "DataValidationError"
+	// ReasonTemplateRenderingError means executing the template failed, e.g. a missing key; Line and Column are set if known
+	ReasonTemplateRenderingError = "TemplateRenderingError"
+	// ReasonOutputValidationError means the rendered output is not well-formed for its output type; Line and Column are set if known
+	ReasonOutputValidationError = "OutputValidationError"
+	// ReasonRateLimited means the client has sent too many requests
+	ReasonRateLimited = "RateLimited"
+	// ReasonInternalError means something unexpected went wrong in the server
+	ReasonInternalError = "InternalError"
+	// ReasonRenderTimeout means rendering took longer than --render-timeout (or --job-timeout for a job), or an item of a batch was not rendered in time
+	ReasonRenderTimeout = "RenderTimeout"
+	// ReasonQueueFull means there are already --max-queued-jobs jobs waiting to be rendered
+	ReasonQueueFull = "QueueFull"
+	// ReasonServerBusy means --max-renders templates are already being rendered
+	ReasonServerBusy = "ServerBusy"
+)
+
+// reasonStatus is the HTTP status code of each error reason
+var reasonStatus = map[string]int{
+	ReasonRequestError:           http.StatusBadRequest,
+	ReasonInvalidOption:          http.StatusBadRequest,
+	ReasonDataUnmarshallingError: http.StatusBadRequest,
+	ReasonSchemaError:            http.StatusBadRequest,
+	ReasonUnauthorized:           http.StatusUnauthorized,
+	ReasonForbidden:              http.StatusForbidden,
+	ReasonUploadNotAllowed:       http.StatusForbidden,
+	ReasonTemplateNotFound:       http.StatusNotFound,
+	ReasonJobNotFound:            http.StatusNotFound,
+	ReasonJobNotFinished:         http.StatusConflict,
+	ReasonPreconditionFailed:     http.StatusPreconditionFailed,
+	ReasonRequestTooLarge:        http.StatusRequestEntityTooLarge,
+	ReasonTemplateTooLarge:       http.StatusRequestEntityTooLarge,
+	ReasonDataTooLarge:           http.StatusRequestEntityTooLarge,
+	ReasonTemplateError:          http.StatusUnprocessableEntity,
+	ReasonDataValidationError:    http.StatusUnprocessableEntity,
+	ReasonTemplateRenderingError: http.StatusUnprocessableEntity,
+	ReasonOutputValidationError:  http.StatusUnprocessableEntity,
+	ReasonRateLimited:            http.StatusTooManyRequests,
+	ReasonInternalError:          http.StatusInternalServerError,
+	ReasonRenderTimeout:          http.StatusGatewayTimeout,
+	ReasonQueueFull:              http.StatusServiceUnavailable,
+	ReasonServerBusy:             http.StatusServiceUnavailable,
+}
+
+type HttpError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	// Line and Column are the 1-based position of the error in the template or data, if known
+	Line       int                `json:"line,omitempty"`
+	Column     int                `json:"column,omitempty"`
+	Violations []schema.Violation `json:"violations,omitempty"`
+}
+
+type HttpErrorResponse struct {
+	Error HttpError `json:"error"`
+}
+
+// writeHttpError writes the error as JSON with the status code of its reason
+func writeHttpError(w http.ResponseWriter, httpError HttpError) {
+	setError(w, httpError.Reason, httpError.Message)
+	status, ok := reasonStatus[httpError.Reason]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeJson(w, status, HttpErrorResponse{httpError})
+}
+
+// templateError returns an HttpError with the position of a template parse or execution error
+func templateError(reason string, err error) HttpError {
+	line, column := template.ErrorPosition(err)
+	return HttpError{Reason: reason, Message: err.Error(), Line: line, Column: column}
+}
+
+// dataError returns an HttpError with the position of an error from unmarshalling or validating data
+func dataError(reason string, err error) HttpError {
+	httpError := HttpError{Reason: reason, Message: err.Error()}
+	var dataErr *dataformat.Error
+	if errors.As(err, &dataErr) {
+		httpError.Line, httpError.Column = dataErr.Line, dataErr.Column
+	}
+	return httpError
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/joshuagrisham-karolinska/gotmpl"
+)
+
+// ready is set once the server has loaded all schemas and templates and started, and cleared when it shuts down
+var ready atomic.Bool
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type VersionResponse struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"goVersion"`
+	Platform  string            `json:"platform"`
+	Build     map[string]string `json:"build,omitempty"`
+	Profile   string            `json:"profile"`
+}
+
+// handleHealthz reports that the server is running (liveness)
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// handleReadyz reports if the server is ready to handle requests (readiness), i.e. if it has started and is not shutting down
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		writeJson(w, http.StatusServiceUnavailable, HealthResponse{Status: "not ready"})
+		return
+	}
+	writeJson(w, http.StatusOK, HealthResponse{Status: "ready"})
+}
+
+// handleVersion returns the version of gotmpl, some information about how it was built, and the function profile in use
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	response := VersionResponse{
+		Version:   gotmpl.Version,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Profile:   string(defaultProfile),
+	}
+
+	// include the build settings which are useful to identify the build (e.g. vcs.revision, vcs.time, -tags)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		response.Build = make(map[string]string)
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "-tags", "vcs", "vcs.revision", "vcs.time", "vcs.modified", "GOOS", "GOARCH", "CGO_ENABLED":
+				response.Build[setting.Key] = setting.Value
+			}
+		}
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joshuagrisham-karolinska/gotmpl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleReadyz(t *testing.T) {
+
+	defer ready.Store(false)
+
+	w := httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	ready.Store(true)
+	w = httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ready"}`, w.Body.String())
+}
+
+func TestHandleVersion(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	handleVersion(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response VersionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, gotmpl.Version, response.Version)
+	assert.Equal(t, "default", response.Profile)
+	assert.NotEmpty(t, response.GoVersion)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// responseRecorder wraps a ResponseWriter to record information about the request and response (status, size, error reason etc)
+// so that it can be included in metrics and logs once the request has been handled
+type responseRecorder struct {
+	http.ResponseWriter
+	handler      string
+	requestID    string
+	client       string
+	status       int
+	wroteHeader  bool
+	size         int64
+	reason       string
+	message      string
+	template     string
+	templateHash string
+	dataFormat   string
+	data         []byte
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.size += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the underlying ResponseWriter supports it
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter so that http.ResponseController can use it
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// instrument wraps an HTTP handler function so that metrics are recorded and a log entry is written for each request,
+// labeled with the given handler name. Each request gets an id which is returned in the X-Request-Id response header.
+func instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, handler: handler, requestID: requestID(r), client: clientIdentity(r), status: http.StatusOK}
+		w.Header().Set("X-Request-Id", rec.requestID)
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		next(rec, r)
+		duration := time.Since(start)
+		metrics.observeRequest(rec, body.n, duration)
+		logRequest(rec, r, body.n, duration)
+	}
+}
+
+// recorderOf returns the responseRecorder of an instrumented ResponseWriter, or nil
+func recorderOf(w http.ResponseWriter) *responseRecorder {
+	rec, _ := w.(*responseRecorder)
+	return rec
+}
+
+// setError records the reason and message of an error response
+func setError(w http.ResponseWriter, reason string, message string) {
+	if rec := recorderOf(w); rec != nil {
+		rec.reason = reason
+		rec.message = message
+	}
+}
+
+// setTemplateName records the name of the named template which is rendered by the request
+func setTemplateName(w http.ResponseWriter, name string) {
+	if rec := recorderOf(w); rec != nil {
+		rec.template = name
+	}
+}
+
+// setTemplateHash records the hash of the template text which is rendered by the request
+func setTemplateHash(w http.ResponseWriter, hash string) {
+	if rec := recorderOf(w); rec != nil {
+		rec.templateHash = hash
+	}
+}
+
+// setData records the format and the raw data payload of the request
+func setData(w http.ResponseWriter, format string, data []byte) {
+	if rec := recorderOf(w); rec != nil {
+		rec.dataFormat = format
+		rec.data = data
+	}
+}
+
+// observeRender records how long it took to execute a template
+func observeRender(w http.ResponseWriter, duration time.Duration) {
+	handler := ""
+	if rec := recorderOf(w); rec != nil {
+		handler = rec.handler
+	}
+	metrics.renderDuration.observe(duration.Seconds(), handler)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docopt/docopt-go"
+)
+
+// The statuses of a job
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobRequest is the body of POST /jobs: a template (or the name of a named template) and its data
+type JobRequest struct {
+	Template string          `json:"template,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Data     json.RawMessage `json:"data"`
+	Options  RenderOptions   `json:"options"`
+}
+
+// Job is the status of a job
+type Job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Expires is when the job and its result will be removed, once it has finished
+	Expires     *time.Time `json:"expires,omitempty"`
+	ContentType string     `json:"contentType,omitempty"`
+	MissingKeys []string   `json:"missingKeys,omitempty"`
+	Error       *HttpError `json:"error,omitempty"`
+}
+
+// job is a Job with the request and the client which sent it, which is also how it is persisted;
+// the output is kept in memory unless the jobs are persisted to a directory
+type job struct {
+	Job
+	Client  string     `json:"client,omitempty"`
+	Request JobRequest `json:"request"`
+	output  []byte
+}
+
+// jobs is the job queue of the server
+var jobs *jobQueue
+
+// jobQueue renders jobs in the background with a fixed number of workers, and keeps finished jobs until
+// they expire; if dir is set, each job is saved there as <id>.json with its result in <id>.result
+type jobQueue struct {
+	dir       string
+	workers   int
+	retention time.Duration
+	timeout   time.Duration
+	pending   chan *job
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	now  func() time.Time
+}
+
+// parseJobs returns the job queue of the options, with the jobs which were saved in --job-dir (if set)
+func parseJobs(opts docopt.Opts) (*jobQueue, error) {
+	value, _ := opts.String("--job-workers")
+	workers, err := strconv.Atoi(value)
+	if err != nil || workers < 1 {
+		return nil, fmt.Errorf("invalid --job-workers '%s'", value)
+	}
+	value, _ = opts.String("--max-queued-jobs")
+	maxQueued, err := strconv.Atoi(value)
+	if err != nil || maxQueued < 1 {
+		return nil, fmt.Errorf("invalid --max-queued-jobs '%s'", value)
+	}
+	value, _ = opts.String("--job-retention")
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return nil, fmt.Errorf("invalid --job-retention '%s' (must be a duration greater than 0)", value)
+	}
+	value, _ = opts.String("--job-timeout")
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --job-timeout: %w", err)
+	}
+	dir, _ := opts.String("--job-dir")
+	return newJobQueue(dir, workers, maxQueued, retention, timeout)
+}
+
+func newJobQueue(dir string, workers int, maxQueued int, retention time.Duration, timeout time.Duration) (*jobQueue, error) {
+	q := &jobQueue{
+		dir:       dir,
+		workers:   workers,
+		retention: retention,
+		timeout:   timeout,
+		pending:   make(chan *job, maxQueued),
+		jobs:      make(map[string]*job),
+		now:       time.Now,
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// load reads the saved jobs; jobs which had not finished are queued again, since they were stopped by the restart
+func (q *jobQueue) load() error {
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	unfinished := []*job{}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		j := &job{}
+		if err := json.Unmarshal(b, j); err != nil {
+			return fmt.Errorf("could not read job %s: %w", file, err)
+		}
+		q.jobs[j.ID] = j
+		if j.Status == JobQueued || j.Status == JobRunning {
+			j.Status, j.Started = JobQueued, nil
+			unfinished = append(unfinished, j)
+		}
+	}
+	sort.Slice(unfinished, func(a, b int) bool { return unfinished[a].Created.Before(unfinished[b].Created) })
+	go func() {
+		for _, j := range unfinished {
+			q.pending <- j
+		}
+	}()
+	if len(files) > 0 {
+		log.Printf("Loaded %d job(s) from %s; %d queued\n", len(files), q.dir, len(unfinished))
+	}
+	return nil
+}
+
+// start starts the workers, and removes expired jobs every minute (or every retention, if that is shorter), until ctx is done;
+// a worker finishes the job it is running first, and jobs which are still queued are lost unless they are saved in dir
+func (q *jobQueue) start(ctx context.Context) {
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for {
+				select {
+				case j := <-q.pending:
+					q.run(j)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		ticker := time.NewTicker(min(q.retention, time.Minute))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				q.purge()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// submit adds a job to the queue; q.mu is held until the job has been added so that a worker cannot start it before that
+func (q *jobQueue) submit(j *job) *HttpError {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- j:
+	default:
+		return &HttpError{Reason: ReasonQueueFull, Message: fmt.Sprintf("there are already %d jobs waiting to be rendered", cap(q.pending))}
+	}
+	q.jobs[j.ID] = j
+	q.save(j)
+	return nil
+}
+
+// get returns the status of a job, the client which sent it and, if it has succeeded, its output
+func (q *jobQueue) get(id string) (Job, string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, "", false
+	}
+	return j.Job, j.Client, true
+}
+
+// result returns the output of a job which has succeeded
+func (q *jobQueue) result(id string) ([]byte, error) {
+	if q.dir != "" {
+		return os.ReadFile(filepath.Join(q.dir, id+".result"))
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return j.output, nil
+}
+
+// run renders a job; its template is compiled again (and a named template looked up again) since a
+// job which was saved before a restart only has its request
+func (q *jobQueue) run(j *job) {
+
+	q.mu.Lock()
+	started := q.now()
+	j.Status, j.Started = JobRunning, &started
+	q.save(j)
+	q.mu.Unlock()
+
+	var client *AuthClient
+	var httpErr *HttpError
+	if auth != nil && j.Client != "" {
+		if client = auth.client(j.Client); client == nil {
+			httpErr = &HttpError{Reason: ReasonForbidden, Message: fmt.Sprintf("client '%s' is not allowed to use the server anymore", j.Client)}
+		}
+	}
+
+	req := RenderRequest{Template: j.Request.Template, Data: j.Request.Data, Options: j.Request.Options}
+	var result rendered
+	if httpErr == nil {
+		tmpl, err := compileBatchTemplate(client, j.Request.Name, &req)
+		httpErr = err
+		if httpErr == nil {
+			ctx := withRenderWait(withRenderTimeout(context.Background(), q.timeout))
+			result, httpErr = render(ctx, &responseRecorder{handler: "jobs"}, req, tmpl)
+		}
+	}
+
+	var writeErr error
+	if httpErr == nil && q.dir != "" {
+		writeErr = writeFileAtomic(filepath.Join(q.dir, j.ID+".result"), result.output)
+		if writeErr != nil {
+			httpErr = &HttpError{Reason: ReasonInternalError, Message: fmt.Sprintf("could not save the result: %s", writeErr)}
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := q.now()
+	expires := finished.Add(q.retention)
+	j.Finished, j.Expires = &finished, &expires
+	if httpErr != nil {
+		j.Status, j.Error = JobFailed, httpErr
+	} else {
+		j.Status, j.ContentType, j.MissingKeys = JobSucceeded, result.contentType, result.missingKeys
+		if q.dir == "" {
+			j.output = result.output
+		}
+	}
+	q.save(j)
+}
+
+// purge removes the jobs which have expired
+func (q *jobQueue) purge() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	for id, j := range q.jobs {
+		if j.Expires != nil && now.After(*j.Expires) {
+			delete(q.jobs, id)
+			if q.dir != "" {
+				os.Remove(filepath.Join(q.dir, id+".json"))
+				os.Remove(filepath.Join(q.dir, id+".result"))
+			}
+		}
+	}
+}
+
+// save writes a job to the jobs directory, if there is one; q.mu must be held
+func (q *jobQueue) save(j *job) {
+	if q.dir == "" {
+		return
+	}
+	b, _ := json.Marshal(j)
+	if err := writeFileAtomic(filepath.Join(q.dir, j.ID+".json"), b); err != nil {
+		log.Printf("Could not save job %s: %s\n", j.ID, err)
+	}
+}
+
+// writeFileAtomic writes a file by renaming a temporary file, so that a crash never leaves a partially written file
+func writeFileAtomic(file string, b []byte) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// newJobID returns a random job id
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// handleJobs handles POST /jobs, GET /jobs/{id} and GET /jobs/{id}/result
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+	id, result := strings.CutSuffix(id, "/result")
+
+	if id == "" {
+		if r.Method != http.MethodPost {
+			w.Header().Add("Allow", "POST")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		submitJob(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", "GET")
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// a client can only see its own jobs
+	status, client, ok := jobs.get(id)
+	if ok && client != "" && (authClient(r) == nil || authClient(r).Name != client) {
+		ok = false
+	}
+	if !ok {
+		writeHttpError(w, HttpError{Reason: ReasonJobNotFound, Message: fmt.Sprintf("job '%s' not found", id)})
+		return
+	}
+	if !result {
+		writeJson(w, http.StatusOK, status)
+		return
+	}
+
+	switch status.Status {
+	case JobFailed:
+		writeHttpError(w, *status.Error)
+	case JobSucceeded:
+		output, err := jobs.result(id)
+		if err != nil {
+			writeHttpError(w, HttpError{Reason: ReasonInternalError, Message: fmt.Sprintf("could not read the result of job '%s': %s", id, err)})
+			return
+		}
+		if status.MissingKeys != nil {
+			w.Header().Set("X-Gotmpl-Missing-Keys", strings.Join(status.MissingKeys, ","))
+		}
+		w.Header().Set("Content-Type", status.ContentType)
+		w.Write(output)
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeHttpError(w, HttpError{Reason: ReasonJobNotFinished, Message: fmt.Sprintf("job '%s' is %s", id, status.Status)})
+	}
+}
+
+// submitJob validates a job request (including compiling its template) and adds it to the queue
+func submitJob(w http.ResponseWriter, r *http.Request) {
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: "job requests must be sent as application/json"})
+		return
+	}
+	var jobReq JobRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&jobReq); err != nil {
+		writeRequestError(w, fmt.Errorf("could not read JSON request body: %w", err))
+		return
+	}
+	if (jobReq.Template == "") == (jobReq.Name == "") {
+		writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: "a job must have either template or name, but not both"})
+		return
+	}
+	if httpErr := dataSizeError(jobReq.Data); httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
+
+	client := authClient(r)
+	req := RenderRequest{Template: jobReq.Template, Data: jobReq.Data, Options: jobReq.Options}
+	if _, httpErr := compileBatchTemplate(client, jobReq.Name, &req); httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
+	if jobReq.Name != "" {
+		setTemplateName(w, jobReq.Name)
+	}
+
+	j := &job{Job: Job{ID: newJobID(), Status: JobQueued, Created: jobs.now()}, Request: jobReq}
+	if client != nil {
+		j.Client = client.Name
+	}
+	if httpErr := jobs.submit(j); httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
+	status, _, _ := jobs.get(j.ID)
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJson(w, http.StatusAccepted, status)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForJob polls the status of a job until it has finished
+func waitForJob(t *testing.T, id string) Job {
+	for i := 0; i < 200; i++ {
+		status, _, ok := jobs.get(id)
+		if !assert.True(t, ok, id) {
+			t.FailNow()
+		}
+		if status.Status == JobSucceeded || status.Status == JobFailed {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+// submitTestJob posts a job request and returns the response and the submitted job
+func submitTestJob(t *testing.T, body string) (*httptest.ResponseRecorder, Job) {
+	w := httptest.NewRecorder()
+	handleJobs(w, newJSONRequest("/jobs", body))
+	var status Job
+	if w.Code == http.StatusAccepted {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		assert.Equal(t, "/jobs/"+status.ID, w.Header().Get("Location"))
+	}
+	return w, status
+}
+
+func TestHandleJobs(t *testing.T) {
+
+	templates = newRegistry()
+	templates.put("greeting", `Hi {{ .name }}`, sourceUpload)
+	var err error
+	jobs, err = newJobQueue("", 2, 10, time.Hour, 0)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.start(ctx)
+	defer func() {
+		cancel()
+		templates = newRegistry()
+		jobs = nil
+	}()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleJobs(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	// a job which succeeds
+	w, status := submitTestJob(t, `{"name": "greeting", "data": {"name": "a"}, "options": {"reportMissing": true}}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, JobQueued, status.Status)
+	status = waitForJob(t, status.ID)
+	assert.Equal(t, JobSucceeded, status.Status)
+	assert.NotNil(t, status.Expires)
+	w = get("/jobs/" + status.ID + "/result")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hi a", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	w = get("/jobs/" + status.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// a job which fails has the error of the render as its result
+	_, status = submitTestJob(t, `{"template": "{{ .a.b }}", "data": {"a": 1}}`)
+	status = waitForJob(t, status.ID)
+	assert.Equal(t, JobFailed, status.Status)
+	assert.Equal(t, ReasonTemplateRenderingError, status.Error.Reason)
+	w = get("/jobs/" + status.ID + "/result")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		reason string
+	}{
+		{"template and name", `{"template": "a", "name": "greeting"}`, http.StatusBadRequest, ReasonRequestError},
+		{"unknown field", `{"tmpl": "a"}`, http.StatusBadRequest, ReasonRequestError},
+		{"template error", `{"template": "{{ .a "}`, http.StatusUnprocessableEntity, ReasonTemplateError},
+		{"missing template", `{"name": "missing"}`, http.StatusNotFound, ReasonTemplateNotFound},
+	}
+	for _, tt := range tests {
+		w, _ := submitTestJob(t, tt.body)
+		assert.Equal(t, tt.status, w.Code, tt.name)
+		assert.Contains(t, w.Body.String(), tt.reason, tt.name)
+	}
+
+	// the name of a missing template is not recorded for metrics
+	rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	handleJobs(rec, newJSONRequest("/jobs", `{"name": "missing"}`))
+	assert.Empty(t, rec.template)
+
+	assert.Equal(t, http.StatusNotFound, get("/jobs/missing").Code)
+	assert.Equal(t, http.StatusNotFound, get("/jobs/missing/result").Code)
+	w = httptest.NewRecorder()
+	handleJobs(w, httptest.NewRequest(http.MethodDelete, "/jobs/"+status.ID, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET", w.Header().Get("Allow"))
+}
+
+func TestJobQueueFull(t *testing.T) {
+
+	templates = newRegistry()
+	var err error
+	jobs, err = newJobQueue("", 1, 1, time.Hour, 0)
+	assert.NoError(t, err)
+	defer func() { jobs = nil }()
+
+	// the queue has not been started, so the first job stays queued
+	w, status := submitTestJob(t, `{"template": "a"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = httptest.NewRecorder()
+	handleJobs(w, httptest.NewRequest(http.MethodGet, "/jobs/"+status.ID+"/result", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), ReasonJobNotFinished)
+
+	w, _ = submitTestJob(t, `{"template": "b"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), ReasonQueueFull)
+}
+
+func TestJobRetention(t *testing.T) {
+
+	templates = newRegistry()
+	var err error
+	jobs, err = newJobQueue("", 1, 10, time.Minute, 0)
+	assert.NoError(t, err)
+	now := time.Now()
+	jobs.now = func() time.Time { return now }
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.start(ctx)
+	defer func() {
+		cancel()
+		jobs = nil
+	}()
+
+	_, status := submitTestJob(t, `{"template": "a"}`)
+	waitForJob(t, status.ID)
+	jobs.purge()
+	_, _, ok := jobs.get(status.ID)
+	assert.True(t, ok)
+
+	// finished jobs are removed once the retention has passed
+	now = now.Add(2 * time.Minute)
+	jobs.purge()
+	_, _, ok = jobs.get(status.ID)
+	assert.False(t, ok)
+}
+
+func TestJobDir(t *testing.T) {
+
+	templates = newRegistry()
+	templates.put("greeting", `Hi {{ .name }}`, sourceUpload)
+	dir := t.TempDir()
+	var err error
+	jobs, err = newJobQueue(dir, 1, 10, time.Hour, 0)
+	assert.NoError(t, err)
+	defer func() {
+		templates = newRegistry()
+		jobs = nil
+	}()
+
+	// jobs which have not been run before a restart are run after it
+	_, queued := submitTestJob(t, `{"name": "greeting", "data": {"name": "a"}}`)
+	assert.FileExists(t, filepath.Join(dir, queued.ID+".json"))
+
+	jobs, err = newJobQueue(dir, 1, 10, time.Hour, 0)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.start(ctx)
+	status := waitForJob(t, queued.ID)
+	assert.Equal(t, JobSucceeded, status.Status)
+	b, err := os.ReadFile(filepath.Join(dir, queued.ID+".result"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi a", string(b))
+	cancel()
+
+	// and finished jobs and their results are still there after another restart
+	jobs, err = newJobQueue(dir, 1, 10, time.Hour, 0)
+	assert.NoError(t, err)
+	status, _, ok := jobs.get(queued.ID)
+	assert.True(t, ok)
+	assert.Equal(t, JobSucceeded, status.Status)
+	w := httptest.NewRecorder()
+	handleJobs(w, httptest.NewRequest(http.MethodGet, "/jobs/"+queued.ID+"/result", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hi a", w.Body.String())
+}
+
+func TestJobQueueStop(t *testing.T) {
+
+	templates = newRegistry()
+	var err error
+	jobs, err = newJobQueue("", 2, 10, time.Hour, 0)
+	assert.NoError(t, err)
+	defer func() { jobs = nil }()
+
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.start(ctx)
+	_, status := submitTestJob(t, `{"template": "a"}`)
+	waitForJob(t, status.ID)
+
+	// the workers and the purging of expired jobs stop once ctx is done
+	cancel()
+	// (not using assert.Eventually, which runs the condition in another goroutine)
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+}
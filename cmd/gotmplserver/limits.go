@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// maxTemplateBytes is the maximum size of a template sent in a request or uploaded (0 means no limit)
+	maxTemplateBytes int64
+	// maxDataBytes is the maximum size of the data sent in a request (0 means no limit)
+	maxDataBytes int64
+)
+
+// writeRequestError writes an error from reading the request; 413 if the body is larger than --max-body-bytes, otherwise 400
+func writeRequestError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeHttpError(w, HttpError{Reason: ReasonRequestTooLarge, Message: fmt.Sprintf("request body is larger than the limit of %d bytes", maxBytesErr.Limit)})
+		return
+	}
+	writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: err.Error()})
+}
+
+// templateSizeError returns an error if the template is larger than --max-template-bytes
+func templateSizeError(name string, text string) *HttpError {
+	if maxTemplateBytes > 0 && int64(len(text)) > maxTemplateBytes {
+		return &HttpError{Reason: ReasonTemplateTooLarge, Message: fmt.Sprintf("template '%s' is %d bytes which is larger than the limit of %d bytes", name, len(text), maxTemplateBytes)}
+	}
+	return nil
+}
+
+// dataSizeError returns an error if the data (as sent) is larger than --max-data-bytes
+func dataSizeError(data []byte) *HttpError {
+	if maxDataBytes == 0 {
+		return nil
+	}
+	if size := dataSize(data); int64(size) > maxDataBytes {
+		return &HttpError{Reason: ReasonDataTooLarge, Message: fmt.Sprintf("data is %d bytes which is larger than the limit of %d bytes", size, maxDataBytes)}
+	}
+	return nil
+}
+
+// dataSize returns the size of the data as sent: the text of data sent as a string (e.g. a form value, which is
+// quoted as a JSON string by readRenderRequest), otherwise the size of the JSON
+func dataSize(data []byte) int {
+	raw := bytes.TrimSpace(data)
+	if len(raw) > 0 && raw[0] == '"' {
+		var dataString string
+		if err := json.Unmarshal(raw, &dataString); err == nil {
+			return len(dataString)
+		}
+	}
+	return len(data)
+}
+
+// limiter is the rate limiter for all API requests; rate limiting is disabled if it is nil
+var limiter *rateLimiter
+
+// authFailureLimiter limits failed authentications by IP address (with --auth), separately from limiter so that
+// API keys can not be guessed even if requests are not rate limited; it is disabled if it is nil
+var authFailureLimiter *rateLimiter
+
+// rateLimiter is a token bucket rate limiter with one bucket per key (client or IP address)
+type rateLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64 // size of each bucket
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// allow takes a token from the bucket of key and returns true, or returns false and how long
+// until a token is available if the bucket is empty
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.purge(now)
+
+	b := rl.fill(key, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// check is allow without taking a token, i.e. it only returns false if the bucket of key is already empty
+func (rl *rateLimiter) check(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b := rl.fill(key, rl.now())
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	return true, 0
+}
+
+// fill returns the bucket of key with the tokens added since it was last used; rl.mu must be held
+func (rl *rateLimiter) fill(key string, now time.Time) *bucket {
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	return b
+}
+
+// purge removes the buckets which would be full by now (so they are the same as a new bucket), at most once a minute
+func (rl *rateLimiter) purge(now time.Time) {
+	if now.Sub(rl.lastPurge) < time.Minute {
+		return
+	}
+	rl.lastPurge = now
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// rateLimitKey returns the key which requests are limited by: the authenticated client, or otherwise the client IP address
+func rateLimitKey(r *http.Request) string {
+	if client := authClient(r); client != nil {
+		return "client:" + client.Name
+	}
+	return "ip:" + remoteHost(r)
+}
+
+// authFailureKey returns the key which failed authentications are limited by: the client IP address, since there is no client
+func authFailureKey(r *http.Request) string {
+	return "auth:" + remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimited wraps an HTTP handler function so that requests over the rate limit (if enabled) get 429 Too Many Requests
+func rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next(w, r)
+			return
+		}
+		if ok, retryAfter := limiter.allow(rateLimitKey(r)); !ok {
+			writeRateLimited(w, retryAfter)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeRateLimited writes 429 Too Many Requests with the duration until the next request is allowed
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeHttpError(w, HttpError{Reason: ReasonRateLimited, Message: fmt.Sprintf("rate limit exceeded; retry after %s", retryAfter.Round(time.Millisecond))})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+
+	now := time.Now()
+	rl := newRateLimiter(2, 3)
+	rl.now = func() time.Time { return now }
+
+	// the burst is available at once
+	for i := 0; i < 3; i++ {
+		ok, _ := rl.allow("a")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := rl.allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// other keys have their own bucket
+	ok, _ = rl.allow("b")
+	assert.True(t, ok)
+
+	// tokens are added at the rate
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = rl.allow("a")
+	assert.True(t, ok)
+	ok, _ = rl.allow("a")
+	assert.False(t, ok)
+
+	// full buckets are purged
+	now = now.Add(2 * time.Minute)
+	rl.allow("c")
+	assert.Len(t, rl.buckets, 1)
+}
+
+func TestRateLimited(t *testing.T) {
+
+	limiter = newRateLimiter(1, 1)
+	defer func() { limiter = nil }()
+
+	handler := rateLimited(func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/gotmpl", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/gotmpl", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestRateLimitedAuthFailures(t *testing.T) {
+
+	dir, _, _ := writeTestAuth(t)
+	var err error
+	auth, err = loadAuth(filepath.Join(dir, "auth.yaml"))
+	assert.NoError(t, err)
+	// failed authentications are limited even without a rate limit for all requests
+	authFailureLimiter = newRateLimiter(1, 2)
+	defer func() {
+		auth = nil
+		authFailureLimiter = nil
+	}()
+
+	request := func(key string) int {
+		r := httptest.NewRequest(http.MethodPost, "/gotmpl", nil)
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		api("render", func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		return w.Code
+	}
+
+	// successful authentications do not use up the limit of failed ones
+	assert.Equal(t, http.StatusOK, request("admin-key"))
+	assert.Equal(t, http.StatusUnauthorized, request("guess-1"))
+	assert.Equal(t, http.StatusUnauthorized, request("guess-2"))
+	// once the IP address has failed too often it is limited before authenticating
+	assert.Equal(t, http.StatusTooManyRequests, request("guess-3"))
+	assert.Equal(t, http.StatusTooManyRequests, request("admin-key"))
+}
+
+func TestSizeLimits(t *testing.T) {
+
+	maxTemplateBytes, maxDataBytes = 10, 20
+	defer func() { maxTemplateBytes, maxDataBytes = 0, 0 }()
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		reason  string
+	}{{
+		name:    "body",
+		request: newFormRequest("/gotmpl", map[string]string{"template": "{{ .a }}", "data": strings.Repeat("a", 1000)}),
+		reason:  "RequestTooLarge",
+	}, {
+		name:    "json body",
+		request: newJSONRequest("/gotmpl", `{"template": "`+strings.Repeat("a", 1000)+`"}`),
+		reason:  "RequestTooLarge",
+	}, {
+		name:    "template",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ .aaaaaaaa }}"}`),
+		reason:  "TemplateTooLarge",
+	}, {
+		name:    "data",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ .a }}", "data": {"a": "aaaaaaaaaaaaaaaaaaaa"}}`),
+		reason:  "DataTooLarge",
+	}}
+
+	handler := limitBody(http.HandlerFunc(handlePath), 500)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, tt.request)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, tt.name)
+		var response HttpErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, tt.reason, response.Error.Reason, tt.name)
+	}
+	// the limit applies to the data as sent, not to form data once it has been quoted as a JSON string
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newFormRequest("/gotmpl", map[string]string{"template": "{{ .a }}", "data": "a: \"x\"\nb: \"yyyy\"\n"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
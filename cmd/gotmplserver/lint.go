@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+type LintResponse struct {
+	Valid  bool             `json:"valid"`
+	Issues []template.Issue `json:"issues"`
+}
+
+// handleLint parses all templates in the request without rendering them and returns the issues found.
+// Templates can be sent as one or more "template" form values (named template, template-2, etc.)
+// and/or as multipart/form-data file uploads named "template" (named by their file name).
+func handleLint(w http.ResponseWriter, r *http.Request) {
+
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		w.Header().Add("Allow", "POST")
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client := authClient(r)
+	if !client.allowsInline() {
+		writeHttpError(w, *inlineForbidden())
+		return
+	}
+
+	// ParseMultipartForm also parses url-encoded forms and query parameters; ErrNotMultipart is fine here
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil && err != http.ErrNotMultipart {
+		writeRequestError(w, err)
+		return
+	}
+
+	sources := []template.Source{}
+	for i, tmpl := range r.Form["template"] {
+		name := "template"
+		if i > 0 {
+			name = fmt.Sprintf("template-%d", i+1)
+		}
+		sources = append(sources, template.Source{Name: name, Text: tmpl})
+	}
+	if r.MultipartForm != nil {
+		for _, fh := range r.MultipartForm.File["template"] {
+			f, err := fh.Open()
+			if err != nil {
+				writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: err.Error()})
+				return
+			}
+			tmplBytes, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: err.Error()})
+				return
+			}
+			sources = append(sources, template.Source{Name: fh.Filename, Text: string(tmplBytes)})
+		}
+	}
+	for _, source := range sources {
+		if httpErr := templateSizeError(source.Name, source.Text); httpErr != nil {
+			writeHttpError(w, *httpErr)
+			return
+		}
+	}
+	if len(sources) == 0 {
+		writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: "at least one template is required"})
+		return
+	}
+
+	engine := newEngine()
+	engine.Profile = client.profile(engine.Profile)
+	response := LintResponse{Valid: true, Issues: engine.Lint(sources...)}
+	for _, issue := range response.Issues {
+		if issue.Severity == template.SeverityError {
+			response.Valid = false
+		}
+	}
+
+	responseBytes, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// redactData replaces the data payload with its size when requests are logged at debug level
+var redactData bool
+
+// setupLogging makes the default logger (including the standard log package) write JSON to stderr at the given level
+func setupLogging(level string, redact bool) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unsupported log level '%s' (must be one of debug, info, warn, error)", level)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: l})))
+	redactData = redact
+	return nil
+}
+
+// fatal logs v at error level, so that it is logged whatever the log level, and exits
+func fatal(v ...any) {
+	slog.Error(fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+// requestID returns the X-Request-Id sent by the client, or a new random id if none (or an unreasonably long one) was sent
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= 128 {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequest logs a request once it has been handled; at info level for successful requests,
+// at warn level for client errors and at error level for server errors
+func logRequest(rec *responseRecorder, r *http.Request, requestSize int64, duration time.Duration) {
+
+	level := slog.LevelInfo
+	switch {
+	case rec.status >= 500:
+		level = slog.LevelError
+	case rec.status >= 400:
+		level = slog.LevelWarn
+	}
+
+	ctx := context.Background()
+	logger := slog.Default()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("requestId", rec.requestID),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("remoteAddr", r.RemoteAddr),
+		slog.String("handler", rec.handler),
+		slog.Int("status", rec.status),
+		slog.Float64("durationMs", float64(duration.Microseconds())/1000),
+		slog.Int64("requestSize", requestSize),
+		slog.Int64("responseSize", rec.size),
+	}
+	if rec.client != "" {
+		attrs = append(attrs, slog.String("client", rec.client))
+	}
+	if rec.template != "" {
+		attrs = append(attrs, slog.String("template", rec.template))
+	}
+	if rec.templateHash != "" {
+		attrs = append(attrs, slog.String("templateHash", rec.templateHash))
+	}
+	if rec.dataFormat != "" {
+		attrs = append(attrs, slog.String("dataFormat", rec.dataFormat))
+	}
+	if rec.reason != "" {
+		attrs = append(attrs, slog.String("reason", rec.reason), slog.String("error", rec.message))
+	}
+	if rec.data != nil && logger.Enabled(ctx, slog.LevelDebug) {
+		if redactData {
+			attrs = append(attrs, slog.String("data", fmt.Sprintf("[REDACTED %d bytes]", len(rec.data))))
+		} else {
+			attrs = append(attrs, slog.String("data", string(rec.data)))
+		}
+	}
+
+	logger.LogAttrs(ctx, level, "Request", attrs...)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRequest(t *testing.T) {
+
+	defer func(logger *slog.Logger) {
+		slog.SetDefault(logger)
+		redactData = false
+	}(slog.Default())
+
+	tests := []struct {
+		redact bool
+		data   string
+	}{{
+		redact: false,
+		data:   `{"a": "b"}`,
+	}, {
+		redact: true,
+		data:   `[REDACTED 10 bytes]`,
+	}}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		redactData = tt.redact
+
+		r := newJSONRequest("/gotmpl", `{"template": "{{ .b }}", "data": {"a": "b"}}`)
+		r.Header.Set("X-Request-Id", "test-id")
+		w := httptest.NewRecorder()
+		instrument("render", handlePath)(w, r)
+		assert.Equal(t, "test-id", w.Header().Get("X-Request-Id"))
+
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal(b.Bytes(), &entry))
+		assert.Equal(t, "WARN", entry["level"])
+		assert.Equal(t, "test-id", entry["requestId"])
+		assert.Equal(t, "POST", entry["method"])
+		assert.Equal(t, "/gotmpl", entry["path"])
+		assert.Equal(t, float64(422), entry["status"])
+		assert.Equal(t, "json", entry["dataFormat"])
+		assert.Equal(t, templateHash(`{{ .b }}`), entry["templateHash"])
+		assert.Equal(t, "TemplateRenderingError", entry["reason"])
+		assert.Equal(t, tt.data, entry["data"])
+		assert.Contains(t, entry, "durationMs")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	id := requestID(r)
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, requestID(r))
+}
@@ -1,45 +1,269 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/clbanning/mxj/v2"
 	"github.com/docopt/docopt-go"
 	"github.com/joshuagrisham-karolinska/gotmpl"
+	"github.com/joshuagrisham-karolinska/gotmpl/schema"
 	"github.com/joshuagrisham-karolinska/gotmpl/template"
-	"sigs.k8s.io/yaml"
 )
 
-func main() {
-
-	// Set up and parse options
-	usage := `Start gotmpl HTTP server.
+// usage is the docopt usage of the server; options and their descriptions must be separated by at least two spaces
+const usage = `Start gotmpl HTTP server.
 Usage:
-  gotmplserver
-  gotmplserver [--port <port> --path <path>]
+  gotmplserver [options]
   gotmplserver --help | --version
 
 Options:
-  -h --help         Show this screen.
-  -v --version      Show version.
-  -p --port <port>  HTTP port number [default: 10000].
-  --path <path>     HTTP path [default: /gotmpl].`
+  -h --help                  Show this screen.
+  -v --version               Show version.
+  --config <file>            Read options which are not given on the command line from this YAML or TOML file (see also GOTMPL_* environment variables).
+  -p --port <port>           HTTP port number, on all interfaces [default: 10000].
+  --listen <address>         Listen on host:port (e.g. 127.0.0.1:10000) or a Unix domain socket (unix:/path/to.sock) instead of --port.
+  --socket-mode <mode>       Permissions of the Unix domain socket of --listen [default: 0660].
+  --path <path>              HTTP path [default: /gotmpl].
+  -m --missingkey <policy>   Default for keys missing from the data: error, zero, default or invalid [default: error].
+  --profile <name>           Function profile: default, or hermetic to exclude functions which depend on time, randomness or the network [default: default].
+  --log-level <level>        Log level: debug, info, warn or error; requests are logged at info (warn for client errors, error for server errors) and debug also logs request data [default: info].
+  --redact-data              Do not include request data in debug logs.
+  --schemas <dir>            Directory of JSON Schema files (*.json) which requests can validate their data against by name.
+  --templates <dirs>         Directories (comma separated) of template files (*.tmpl) which can be rendered by name.
+  --timezone <tz>            Local time zone of toLocalDateTime, as a location name or offset, e.g. UTC+2 [default: Europe/Stockholm].
+  --watch <interval>         Also check the --templates directories for changes every interval, e.g. 30s (it is always reloaded on SIGHUP).
+  --allow-upload             Allow templates to be added or replaced using PUT /templates/{name}.
+  --tls-cert <file>          Serve HTTPS using this certificate (PEM); it is reloaded when the file changes.
+  --tls-key <file>           Private key (PEM) of --tls-cert.
+  --client-ca <file>         Require clients to present a certificate signed by one of these CAs (PEM), i.e. mutual TLS.
+  --auth <file>              Require clients to authenticate using API keys, JWTs or client certificates, with per-client rules (YAML).
+  --read-timeout <d>         Maximum duration for reading a request, including the body [default: 30s].
+  --write-timeout <d>        Maximum duration before timing out writing a response [default: 60s].
+  --idle-timeout <d>         Maximum duration to keep an idle keep-alive connection open [default: 120s].
+  --shutdown-timeout <d>     Maximum duration to wait for in-flight requests to finish on SIGTERM or SIGINT [default: 30s].
+  --render-timeout <d>       Maximum duration of rendering a template; 0 for no limit. The request fails after it, but the template keeps running until it writes its next output [default: 10s].
+  --max-renders <n>          Maximum number of templates rendered at the same time, including ones still running after --render-timeout; 0 for no limit [default: 64].
+  --max-header-bytes <n>     Maximum size of request headers [default: 1048576].
+  --max-body-bytes <n>       Maximum size of request bodies [default: 10485760].
+  --max-template-bytes <n>   Maximum size of a template in a request or upload; 0 for no limit [default: 1048576].
+  --max-data-bytes <n>       Maximum size of the data in a request; 0 for no limit [default: 0].
+  --batch-workers <n>        Number of items of a batch which are rendered at the same time, each waiting for one of --max-renders; items not rendered within 90% of --write-timeout fail [default: 4].
+  --max-batch-items <n>      Maximum number of items in a batch; 0 for no limit [default: 1000].
+  --stream-idle-timeout <d>  Maximum duration to wait for each record of a stream, and for writing its result [default: 60s].
+  --rate-limit <n>           Maximum requests per second per client (or per IP address without --auth); 0 for no limit [default: 0].
+  --rate-burst <n>           Number of requests which can be made at once before the rate limit applies [default: 10].
+  --auth-failure-limit <n>   Maximum failed authentications per second per IP address with --auth, independent of --rate-limit; 0 for no limit [default: 0.1].
+  --auth-failure-burst <n>   Number of failed authentications which can be made at once before --auth-failure-limit applies [default: 10].
+  --cache-max-bytes <n>      Cache render results in memory up to this total size; 0 to disable caching [default: 0].
+  --cache-ttl <d>            Maximum duration to keep a cached render result [default: 10m].
+  --job-workers <n>          Number of jobs (POST /jobs) which are rendered at the same time [default: 2].
+  --max-queued-jobs <n>      Maximum number of jobs waiting to be rendered [default: 100].
+  --job-retention <d>        Duration to keep a finished job and its result [default: 1h].
+  --job-timeout <d>          Maximum duration of rendering a job; 0 for no limit [default: 1h].
+  --job-dir <dir>            Save jobs and their results in this directory so that they survive a restart.
+  --cors-origins <list>      Allow browsers on these origins (comma separated patterns, e.g. https://*.example.com, or * for any) to call the API.
+  --cors-methods <list>      Methods which browsers may use in cross-origin requests [default: GET,POST,PUT].
+  --cors-headers <list>      Request headers which browsers may send in cross-origin requests [default: Authorization,Content-Type,If-Match,X-API-Key,X-Request-Id].
+  --cors-max-age <d>         Maximum duration that browsers may cache the result of a preflight request [default: 10m].`
+
+func main() {
 
+	// Set up and parse options
 	opts, _ := docopt.ParseArgs(usage, os.Args[1:], gotmpl.Version)
+	configFile, unknownEnv, err := applyConfig(opts, usage, os.Args[1:], os.Environ())
+	if err != nil {
+		fatal(err)
+	}
 	port, _ := opts.String("--port")
+	listenAddress, _ := opts.String("--listen")
+	socketModeString, _ := opts.String("--socket-mode")
 	path, _ := opts.String("--path")
+	missingKeyString, _ := opts.String("--missingkey")
+	profileString, _ := opts.String("--profile")
+	logLevel, _ := opts.String("--log-level")
+	redact, _ := opts.Bool("--redact-data")
+	tlsCert, _ := opts.String("--tls-cert")
+	tlsKey, _ := opts.String("--tls-key")
+	clientCA, _ := opts.String("--client-ca")
+	authFile, _ := opts.String("--auth")
+	schemasDir, _ := opts.String("--schemas")
+	templatesDir, _ := opts.String("--templates")
+	watchString, _ := opts.String("--watch")
+	allowUpload, _ = opts.Bool("--allow-upload")
+
+	timezone, _ := opts.String("--timezone")
+
+	err = setupLogging(logLevel, redact)
+	if err != nil {
+		fatal(err)
+	}
+	if configFile != "" {
+		log.Printf("Loaded configuration from %s\n", configFile)
+	}
+	if len(unknownEnv) > 0 {
+		slog.Warn("Ignoring GOTMPL_* environment variables which are not options", "variables", unknownEnv)
+	}
+	serverOpts, err := parseServerOptions(opts)
+	if err != nil {
+		fatal(err)
+	}
+	defaultMissingKey, err = template.ParseMissingKey(missingKeyString)
+	if err != nil {
+		fatal(err)
+	}
+	defaultProfile, err = template.ParseProfile(profileString)
+	if err != nil {
+		fatal(err)
+	}
+	defaultLocation, err = template.ParseLocation(timezone)
+	if err != nil {
+		fatal(fmt.Errorf("invalid --timezone: %w", err))
+	}
+
+	if err := parseLimits(opts); err != nil {
+		fatal(err)
+	}
+	if authFile != "" {
+		auth, err = loadAuth(authFile)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	cors, err = parseCORS(opts)
+	if err != nil {
+		fatal(err)
+	}
+	renderCache, err = parseCache(opts)
+	if err != nil {
+		fatal(err)
+	}
+	jobs, err = parseJobs(opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	server := newServer(withCORS(newMux(path)), serverOpts)
+	scheme := "http"
+	if tlsCert != "" || tlsKey != "" || clientCA != "" {
+		if tlsCert == "" || tlsKey == "" {
+			fatal("--tls-cert and --tls-key must both be set to use TLS")
+		}
+		server.TLSConfig, err = newTLSConfig(tlsCert, tlsKey, clientCA)
+		if err != nil {
+			fatal(err)
+		}
+		scheme = "https"
+	}
+
+	// Load the schemas and templates before the server starts, so that no request can see them half loaded
+	if schemasDir != "" {
+		err = loadSchemas(schemasDir)
+		if err != nil {
+			fatal(err)
+		}
+		log.Printf("Loaded %d schema(s) from %s\n", len(schemas), schemasDir)
+	}
+
+	if templatesDir != "" {
+		err = templates.loadDirs(splitList(templatesDir)...)
+		if err != nil {
+			fatal(err)
+		}
+		log.Printf("Loaded %d template(s) from %s\n", len(templates.list()), templatesDir)
+
+		var watchInterval time.Duration
+		if watchString != "" {
+			watchInterval, err = time.ParseDuration(watchString)
+			if err != nil {
+				fatal(err)
+			}
+		}
+		go watchTemplates(watchInterval)
+	}
+
+	// Jobs are started once the templates have been loaded, since saved jobs may use named templates,
+	// and are stopped when the server shuts down
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	jobs.start(ctx)
+
+	// Start the server
+	if listenAddress == "" {
+		listenAddress = ":" + port
+	}
+	socketMode, err := parseSocketMode(socketModeString)
+	if err != nil {
+		fatal(err)
+	}
+	listener, err := listen(listenAddress, socketMode)
+	if err != nil {
+		fatal(err)
+	}
+	if listener.Addr().Network() == "unix" {
+		log.Printf("Starting gotmpl Server; listening on %s over unix:%s\n", path, listener.Addr())
+	} else {
+		log.Printf("Starting gotmpl Server; listening on %s://%s%s\n", scheme, listener.Addr(), path)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, server, listener, serverOpts.shutdownTimeout)
+	}()
+
+	ready.Store(true)
+	log.Println("Ready")
+	if err := <-done; err != nil {
+		fatal(err)
+	}
+
+}
 
-	log.Printf("Starting gotmpl Server; listening on http://0.0.0.0:%s%s\n", port, path)
+// newMux returns the handlers of the server, with the render endpoint at path
+func newMux(path string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, api("render", handlePath))
+	mux.HandleFunc("/lint", api("lint", handleLint))
+	mux.HandleFunc("/templates", api("templates", handleTemplates))
+	mux.HandleFunc("/templates/", api("templates", handleTemplates))
+	mux.HandleFunc("/render/", api("render_named", handleRender))
+	mux.HandleFunc("/render/batch", api("render_batch", handleBatch))
+	mux.HandleFunc("/render/stream", api("render_stream", handleStream))
+	mux.HandleFunc("/jobs", api("jobs", handleJobs))
+	mux.HandleFunc("/jobs/", api("jobs", handleJobs))
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/version", handleVersion)
+	mux.HandleFunc("/openapi.json", handleOpenAPI(path))
+	return mux
+}
+
+// api wraps the handler of an API endpoint so that it is instrumented, authenticated and rate limited
+func api(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return instrument(handler, authenticated(rateLimited(next)))
+}
+
+// defaultMissingKey is the missing key policy used when a request does not specify one
+var defaultMissingKey = template.MissingKeyError
 
-	// Set up HTTP handler functions and start the server
-	http.HandleFunc(path, handlePath)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// defaultProfile is the function profile used for all templates
+var defaultProfile = template.ProfileDefault
 
+// defaultLocation is the local time zone of toLocalDateTime (template.DefaultLocation if nil)
+var defaultLocation *time.Location
+
+// newEngine returns a template Engine with the server's default options
+func newEngine() template.Engine {
+	return template.Engine{MissingKey: defaultMissingKey, Profile: defaultProfile, Location: defaultLocation}
 }
 
 func handlePath(w http.ResponseWriter, r *http.Request) {
@@ -51,74 +275,169 @@ func handlePath(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If text/template execution fails it will panic
-	// In this case we will capture the panic and return an error with reason TemplateError
-	defer func() {
-		if err := recover(); err != nil {
-			writeHttpBadRequest(w, "TemplateError", fmt.Sprintf("%v", err))
-			return
-		}
-	}()
+	req, err := readRenderRequest(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
 
-	// Get template and data from form values
-	// Note: per https://pkg.go.dev/net/http#Request.FormValue
-	//  using FormValue supports the client to set these values in any of:
-	//    - application/x-www-form-urlencoded
-	//    - query parameters
-	//    - multipart/form-data
-	tmpl := r.FormValue("template")
-	dataString := strings.TrimSpace(r.FormValue("data"))
+	setTemplateHash(w, templateHash(req.Template))
+	tmpl, httpErr := compileTemplate(authClient(r), req)
+	if httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
 
-	data := make(map[string]interface{})
-	var err error
+	renderTemplate(w, r, req, tmpl)
+}
 
-	// TODO: Maybe better to support setting and reading Content-Type per part of multipart/form-data ?
-	// for now we will write some logic to try and "guess" JSON vs YAML vs XML by looking at the first character of the data
+// compileTemplate compiles the template of the request using its options, if the client may send its own templates
+func compileTemplate(client *AuthClient, req RenderRequest) (*template.Template, *HttpError) {
 
-	switch {
+	if !client.allowsInline() {
+		return nil, inlineForbidden()
+	}
+	if httpErr := templateSizeError("template", req.Template); httpErr != nil {
+		return nil, httpErr
+	}
 
-	// unmarshall to map[string]interface requires an object at the top level even though valid JSON can start with an array or a single element value
-	// so here we will only try to detect if the data starts with "{" and assume it will be JSON
-	case dataString[:1] == "{":
-		err = json.Unmarshal([]byte(dataString), &data)
+	engine, err := req.Options.engine()
+	if err != nil {
+		return nil, &HttpError{Reason: ReasonInvalidOption, Message: err.Error()}
+	}
+	engine.Profile = client.profile(engine.Profile)
 
-	// beginning with "<" is assumed to be XML
-	case dataString[:1] == "<":
-		data, err = mxj.NewMapXml([]byte(dataString))
+	tmpl, err := engine.Compile(req.Template)
+	if err != nil {
+		httpErr := templateError(ReasonTemplateError, err)
+		return nil, &httpErr
+	}
+	return tmpl, nil
+}
 
-	// otherwise we can just assume it is YAML, I guess ? (since in YAML you can quote key names and stuff..)
-	default:
-		err = yaml.Unmarshal([]byte(dataString), &data)
+// renderTemplate renders the template with the data of the request and writes the result to the ResponseWriter
+func renderTemplate(w http.ResponseWriter, r *http.Request, req RenderRequest, tmpl *template.Template) {
 
+	setData(w, req.dataFormat(), req.Data)
+	result, httpErr := render(r.Context(), w, req, tmpl)
+	if httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
 	}
 
-	if err != nil {
-		writeHttpBadRequest(w, "DataUnmarshallingError", err.Error())
-		return
+	// Report any missing keys in a response header since the body is the rendered output
+	if req.Options.ReportMissing {
+		w.Header().Set("X-Gotmpl-Missing-Keys", strings.Join(result.missingKeys, ","))
 	}
+	w.Header().Set("Content-Type", result.contentType)
 
-	// Render template using data and write the result to the ResponseWriter
-	err = template.Render(tmpl, data, w)
-	if err != nil {
-		writeHttpBadRequest(w, "TemplateRenderingError", err.Error())
-		return
+	// A result which can be cached has an ETag, so that a client which already has it does not need it again
+	if result.etag != "" {
+		w.Header().Set("ETag", result.etag)
+		w.Header().Set("X-Gotmpl-Cache", result.cacheStatus)
+		if etagMatches(r.Header.Get("If-None-Match"), result.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 	}
+	w.Write(result.output)
 
 }
 
-type HttpError struct {
-	Reason  string `json:"reason"`
-	Message string `json:"message"`
+// rendered is the result of rendering a template
+type rendered struct {
+	output      []byte
+	contentType string
+	missingKeys []string
+	// etag and cacheStatus (hit or miss) are set if the result can be cached
+	etag        string
+	cacheStatus string
 }
 
-type HttpErrorResponse struct {
-	Error HttpError `json:"error"`
-}
+// render unmarshals and validates the data of the request and then renders the template
+func render(ctx context.Context, w http.ResponseWriter, req RenderRequest, tmpl *template.Template) (rendered, *HttpError) {
+
+	var result rendered
+	if httpErr := dataSizeError(req.Data); httpErr != nil {
+		return result, httpErr
+	}
 
-func writeHttpBadRequest(w http.ResponseWriter, reason string, message string) {
-	response := HttpErrorResponse{HttpError{reason, message}}
-	responseBytes, _ := json.Marshal(response)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write(responseBytes)
+	var err error
+	result.contentType, err = outputContentType(req.Options.OutputType)
+	if err != nil {
+		return result, &HttpError{Reason: ReasonInvalidOption, Message: err.Error()}
+	}
+	if req.Options.ValidateOutput && outputFormat(req.Options.OutputType) == "" {
+		return result, &HttpError{Reason: ReasonInvalidOption, Message: fmt.Sprintf("validateOutput can not check output type '%s' (must be json, yaml, xml or one of their media types)", req.Options.OutputType)}
+	}
+
+	data, err := req.data()
+	if err != nil {
+		httpErr := dataError(ReasonDataUnmarshallingError, err)
+		return result, &httpErr
+	}
+
+	// Validate the data against a JSON Schema first, if one was requested
+	s, err := requestSchema(req.Options)
+	if err != nil {
+		return result, &HttpError{Reason: ReasonSchemaError, Message: err.Error()}
+	}
+	if s != nil {
+		err = s.Validate(data)
+		if ve, ok := err.(*schema.ValidationError); ok {
+			return result, &HttpError{Reason: ReasonDataValidationError, Message: ve.Error(), Violations: ve.Violations}
+		}
+		if err != nil {
+			return result, &HttpError{Reason: ReasonDataValidationError, Message: err.Error()}
+		}
+	}
+
+	// Use the cached result if there is one; only templates which always give the same output for the same data are cached
+	var key string
+	if renderCache != nil {
+		var cacheable bool
+		key, cacheable = resultKey(tmpl, req.Options, data)
+		if !cacheable {
+			metrics.cacheRequests.inc("uncacheable")
+		} else if cached, ok := renderCache.get(key); ok {
+			metrics.cacheRequests.inc("hit")
+			cached.etag, cached.cacheStatus = resultETag(key), "hit"
+			return cached, nil
+		} else {
+			metrics.cacheRequests.inc("miss")
+		}
+	}
+
+	// Render template using data into a buffer so that nothing is written unless rendering succeeds
+	start := time.Now()
+	result.output, err = executeTemplate(ctx, tmpl, data)
+	observeRender(w, time.Since(start))
+	var panicErr panicError
+	switch {
+	case errors.Is(err, errRenderTimeout):
+		return result, &HttpError{Reason: ReasonRenderTimeout, Message: err.Error()}
+	case errors.Is(err, errRenderBusy):
+		return result, &HttpError{Reason: ReasonServerBusy, Message: err.Error()}
+	case errors.As(err, &panicErr):
+		return result, &HttpError{Reason: ReasonInternalError, Message: err.Error()}
+	case err != nil:
+		httpErr := templateError(ReasonTemplateRenderingError, err)
+		return result, &httpErr
+	}
+
+	if req.Options.ValidateOutput {
+		if err := validateOutput(req.Options.OutputType, result.output); err != nil {
+			httpErr := dataError(ReasonOutputValidationError, err)
+			return result, &httpErr
+		}
+	}
+
+	if req.Options.ReportMissing {
+		result.missingKeys = tmpl.MissingKeys(data)
+	}
+	if key != "" {
+		renderCache.put(key, result)
+		result.etag, result.cacheStatus = resultETag(key), "miss"
+	}
+	return result, nil
 }
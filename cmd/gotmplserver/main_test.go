@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFormRequest creates a multipart/form-data POST request like `curl -F key=value`
+func newFormRequest(target string, values map[string]string) *http.Request {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for k, v := range values {
+		mw.WriteField(k, v)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, target, body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// newJSONRequest creates an application/json POST request
+func newJSONRequest(target string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestHandlePath(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		status  int
+		expect  string
+	}{{
+		name:    "form json",
+		request: newFormRequest("/gotmpl", map[string]string{"template": "{{ .Data.aKey }}", "data": `{"Data": {"aKey": "aValue"}}`}),
+		status:  http.StatusOK,
+		expect:  "aValue",
+	}, {
+		name:    "form xml",
+		request: newFormRequest("/gotmpl", map[string]string{"template": "{{ .Data.aKey }}", "data": `<Data><aKey>aValue</aKey></Data>`}),
+		status:  http.StatusOK,
+		expect:  "aValue",
+	}, {
+		name: "url-encoded yaml with delimiters",
+		request: func() *http.Request {
+			form := url.Values{"template": {"<< .Data.aKey >>"}, "data": {"Data:\n  aKey: aValue"}, "delimiters": {"<< >>"}}
+			r := httptest.NewRequest(http.MethodPost, "/gotmpl", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return r
+		}(),
+		status: http.StatusOK,
+		expect: "aValue",
+	}, {
+		name:    "json object",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ .Data.aKey }}", "data": {"Data": {"aKey": "aValue"}}}`),
+		status:  http.StatusOK,
+		expect:  "aValue",
+	}, {
+		name:    "json string with format",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ .Data.aKey }}", "data": "<Data><aKey>aValue</aKey></Data>", "options": {"format": "xml"}}`),
+		status:  http.StatusOK,
+		expect:  "aValue",
+	}, {
+		name:    "json options",
+		request: newJSONRequest("/gotmpl", `{"template": "[[ .Data.anotherKey ]]", "data": {"Data": {}}, "options": {"missingKey": "zero", "delimiters": ["[[", "]]"]}}`),
+		status:  http.StatusOK,
+		expect:  "<no value>",
+	}, {
+		name:    "json unknown field",
+		request: newJSONRequest("/gotmpl", `{"tmpl": "{{ .Data.aKey }}"}`),
+		status:  http.StatusBadRequest,
+		expect:  `"reason":"RequestError"`,
+	}, {
+		name:    "json array data",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ . }}", "data": [1, 2]}`),
+		status:  http.StatusBadRequest,
+		expect:  `"reason":"DataUnmarshallingError"`,
+	}, {
+		name:    "invalid delimiters",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ . }}", "options": {"delimiters": ["[["]}}`),
+		status:  http.StatusBadRequest,
+		expect:  `"reason":"InvalidOption"`,
+	}, {
+		name:    "schema violation",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ . }}", "data": {}, "options": {"schema": {"required": ["Data"]}}}`),
+		status:  http.StatusUnprocessableEntity,
+		expect:  `"violations":[{"instanceLocation":"","keywordLocation":"/required","message":"missing properties: 'Data'"}]`,
+	}, {
+		name:    "template parse error",
+		request: newJSONRequest("/gotmpl", `{"template": "a\n{{ .a "}`),
+		status:  http.StatusUnprocessableEntity,
+		expect:  `"reason":"TemplateError","message":"template: gotmpl:2: unclosed action","line":2}`,
+	}, {
+		name:    "rendering error is not partially written",
+		request: newJSONRequest("/gotmpl", `{"template": "partial\n{{ .a }}", "data": {}}`),
+		status:  http.StatusUnprocessableEntity,
+		expect:  `{"error":{"reason":"TemplateRenderingError","message":"template: gotmpl:2:3: executing \"gotmpl\" at \u003c.a\u003e: map has no entry for key \"a\"","line":2,"column":4}}`,
+	}, {
+		name:    "data syntax error",
+		request: newFormRequest("/gotmpl", map[string]string{"template": "{{ .a }}", "data": "{\n  \"a\": x\n}"}),
+		status:  http.StatusBadRequest,
+		expect:  `"reason":"DataUnmarshallingError","message":"invalid character 'x' looking for beginning of value","line":2,"column":8}`,
+	}}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handlePath(w, tt.request)
+		assert.Equal(t, tt.status, w.Code, tt.name)
+		assert.Contains(t, w.Body.String(), tt.expect, tt.name)
+	}
+}
+
+func TestRenderTimeout(t *testing.T) {
+
+	renderTimeout = 10 * time.Millisecond
+	defer func() { renderTimeout = 0 }()
+
+	w := httptest.NewRecorder()
+	handlePath(w, newJSONRequest("/gotmpl", `{"template": "{{ range until 10000 }}{{ range until 10000 }}x{{ end }}{{ end }}"}`))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"RenderTimeout"`)
+}
+
+func TestRenderSlots(t *testing.T) {
+
+	renderTimeout = 10 * time.Millisecond
+	renderSlots = make(chan struct{}, 1)
+	defer func() {
+		renderTimeout = 0
+		renderSlots = nil
+	}()
+
+	// a template which never writes any output keeps running after its timeout, and keeps its render slot
+	loop := `{"template": "{{ range until 2000 }}{{ range until 2000 }}{{ end }}{{ end }}"}`
+	w := httptest.NewRecorder()
+	handlePath(w, newJSONRequest("/gotmpl", `{"template": "ok"}`))
+	goroutines := runtime.NumGoroutine()
+	w = httptest.NewRecorder()
+	handlePath(w, newJSONRequest("/gotmpl", loop))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	// so other renders are rejected instead of starting even more goroutines
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		handlePath(w, newJSONRequest("/gotmpl", loop))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), `"reason":"ServerBusy"`)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines+1)
+
+	// once the template has finished, its slot and goroutine are released
+	assert.Eventually(t, func() bool { return len(renderSlots) == 0 }, 30*time.Second, 10*time.Millisecond)
+	// (not using assert.Eventually, which runs the condition in another goroutine)
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+	w = httptest.NewRecorder()
+	handlePath(w, newJSONRequest("/gotmpl", `{"template": "ok"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
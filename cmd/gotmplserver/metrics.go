@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// durationBuckets are the upper bounds (in seconds) of the duration histograms
+	durationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// sizeBuckets are the upper bounds (in bytes) of the size histograms: 64B to 16MiB
+	sizeBuckets = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+)
+
+// serverMetrics are all of the metrics exposed by /metrics
+type serverMetrics struct {
+	requests        *counter
+	requestDuration *histogram
+	renderDuration  *histogram
+	requestSize     *histogram
+	responseSize    *histogram
+	templateRenders *counter
+	cacheRequests   *counter
+}
+
+var metrics = newServerMetrics()
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:        newCounter("gotmpl_http_requests_total", "Number of HTTP requests by handler, status code and error reason.", "handler", "status", "reason"),
+		requestDuration: newHistogram("gotmpl_http_request_duration_seconds", "Duration of HTTP requests.", durationBuckets, "handler"),
+		renderDuration:  newHistogram("gotmpl_render_duration_seconds", "Duration of template execution.", durationBuckets, "handler"),
+		requestSize:     newHistogram("gotmpl_http_request_size_bytes", "Size of HTTP request bodies.", sizeBuckets, "handler"),
+		responseSize:    newHistogram("gotmpl_http_response_size_bytes", "Size of HTTP response bodies.", sizeBuckets, "handler"),
+		templateRenders: newCounter("gotmpl_template_renders_total", "Number of renders of each named template by status code and error reason.", "template", "status", "reason"),
+		cacheRequests:   newCounter("gotmpl_render_cache_requests_total", "Number of render cache lookups by result: hit, miss or uncacheable (the template uses time or random functions).", "result"),
+	}
+}
+
+// observeRequest records the metrics of a request once it has been handled
+func (m *serverMetrics) observeRequest(rec *responseRecorder, requestSize int64, duration time.Duration) {
+	status := strconv.Itoa(rec.status)
+	m.requests.inc(rec.handler, status, rec.reason)
+	m.requestDuration.observe(duration.Seconds(), rec.handler)
+	m.requestSize.observe(float64(requestSize), rec.handler)
+	m.responseSize.observe(float64(rec.size), rec.handler)
+	if rec.template != "" {
+		m.templateRenders.inc(rec.template, status, rec.reason)
+	}
+}
+
+// writeTo writes all metrics in the Prometheus text exposition format
+func (m *serverMetrics) writeTo(w io.Writer) {
+	m.requests.writeTo(w)
+	m.requestDuration.writeTo(w)
+	m.renderDuration.writeTo(w)
+	m.requestSize.writeTo(w)
+	m.responseSize.writeTo(w)
+	m.templateRenders.writeTo(w)
+	m.cacheRequests.writeTo(w)
+	if renderCache != nil {
+		entries, size := renderCache.stats()
+		writeGauge(w, metric{name: "gotmpl_render_cache_entries", help: "Number of results in the render cache."}, float64(entries))
+		writeGauge(w, metric{name: "gotmpl_render_cache_bytes", help: "Total size of the results in the render cache."}, float64(size))
+	}
+}
+
+// writeGauge writes a gauge metric without labels
+func writeGauge(w io.Writer, m metric, value float64) {
+	m.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(value))
+}
+
+// handleMetrics returns all metrics in the Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", "GET")
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.writeTo(w)
+}
+
+// metric holds the description of a metric
+type metric struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// labelString formats label values as `name="value",...`, which is also used as the key of each series
+func (m metric) labelString(values []string) string {
+	pairs := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = label + `="` + escapeLabelValue(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metric) writeHeader(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, kind)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// counter is a metric which only goes up, with one series per combination of label values
+type counter struct {
+	metric
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{metric: metric{name: name, help: help, labels: labels}, series: make(map[string]float64)}
+}
+
+func (c *counter) inc(labelValues ...string) {
+	key := c.labelString(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.series[key]++
+}
+
+func (c *counter) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, key, formatFloat(c.series[key]))
+	}
+}
+
+// histogram counts observations in buckets, with one series per combination of label values
+type histogram struct {
+	metric
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // one per bucket; not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{metric: metric{name: name, help: help, labels: labels}, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogram) observe(value float64, labelValues ...string) {
+	key := h.labelString(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *histogram) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, prefix, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, prefix, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, key, s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+
+	h := newHistogram("test_seconds", "Test histogram.", []float64{1, 5}, "handler")
+	h.observe(0.5, "a")
+	h.observe(2, "a")
+	h.observe(10, "a")
+
+	var b strings.Builder
+	h.writeTo(&b)
+	assert.Equal(t, `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{handler="a",le="1"} 1
+test_seconds_bucket{handler="a",le="5"} 2
+test_seconds_bucket{handler="a",le="+Inf"} 3
+test_seconds_sum{handler="a"} 12.5
+test_seconds_count{handler="a"} 3
+`, b.String())
+}
+
+func TestHandleMetrics(t *testing.T) {
+
+	metrics = newServerMetrics()
+	templates = newRegistry()
+	defer func() {
+		metrics = newServerMetrics()
+		templates = newRegistry()
+	}()
+	templates.put("test", `{{ .a }}`, sourceUpload)
+
+	requests := []*http.Request{
+		newFormRequest("/gotmpl", map[string]string{"template": `{{ .a }}`, "data": `{"a": "b"}`}),
+		newFormRequest("/gotmpl", map[string]string{"template": `{{ .a `, "data": `{}`}),
+		newFormRequest("/gotmpl", map[string]string{"template": `{{ .a }}`, "data": `{"a": `}),
+		newJSONRequest("/render/test", `{"data": {"a": "b"}}`),
+		newJSONRequest("/render/test", `{"data": {}}`),
+	}
+	for _, r := range requests {
+		if strings.HasPrefix(r.URL.Path, "/render/") {
+			instrument("render_named", handleRender)(httptest.NewRecorder(), r)
+		} else {
+			instrument("render", handlePath)(httptest.NewRecorder(), r)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+
+	for _, line := range []string{
+		`gotmpl_http_requests_total{handler="render",status="200",reason=""} 1`,
+		`gotmpl_http_requests_total{handler="render",status="422",reason="TemplateError"} 1`,
+		`gotmpl_http_requests_total{handler="render",status="400",reason="DataUnmarshallingError"} 1`,
+		`gotmpl_http_requests_total{handler="render_named",status="422",reason="TemplateRenderingError"} 1`,
+		`gotmpl_template_renders_total{template="test",status="200",reason=""} 1`,
+		`gotmpl_template_renders_total{template="test",status="422",reason="TemplateRenderingError"} 1`,
+		`gotmpl_render_duration_seconds_count{handler="render_named"} 2`,
+		`gotmpl_http_request_duration_seconds_count{handler="render"} 3`,
+		`gotmpl_http_response_size_bytes_bucket{handler="render",le="64"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
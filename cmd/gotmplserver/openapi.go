@@ -0,0 +1,42 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/joshuagrisham-karolinska/gotmpl"
+)
+
+// openAPISpec is the OpenAPI document of the server, with the render endpoint at its default path /gotmpl
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIDocument returns the OpenAPI document with the version of gotmpl and the render endpoint at the given path
+func openAPIDocument(path string) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		return nil, err
+	}
+	doc["info"].(map[string]interface{})["version"] = gotmpl.Version
+	paths := doc["paths"].(map[string]interface{})
+	if path != "/gotmpl" {
+		paths[path] = paths["/gotmpl"]
+		delete(paths, "/gotmpl")
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// handleOpenAPI returns a handler which serves the OpenAPI document of the server
+func handleOpenAPI(path string) http.HandlerFunc {
+	doc, err := openAPIDocument(path)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			writeHttpError(w, HttpError{Reason: ReasonInternalError, Message: err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
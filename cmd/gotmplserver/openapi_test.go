@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshuagrisham-karolinska/gotmpl"
+	"github.com/joshuagrisham-karolinska/gotmpl/schema"
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+	"github.com/stretchr/testify/assert"
+)
+
+// openAPI is the part of an OpenAPI document which is checked by the tests
+type openAPI struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse        `json:"responses"`
+		Schemas   map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema struct {
+			Ref string `json:"$ref"`
+		} `json:"schema"`
+	} `json:"content"`
+}
+
+func loadOpenAPI(t *testing.T) openAPI {
+	var doc openAPI
+	if !assert.NoError(t, json.Unmarshal(openAPISpec, &doc)) {
+		t.FailNow()
+	}
+	return doc
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	handleOpenAPI("/api/render")(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var doc struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]interface{} `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, gotmpl.Version, doc.Info.Version)
+	assert.Contains(t, doc.Paths, "/api/render")
+	assert.NotContains(t, doc.Paths, "/gotmpl")
+}
+
+// jsonFields returns the names of the JSON fields of a struct type, including the fields of embedded structs
+func jsonFields(typ reflect.Type) []string {
+	fields := []string{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+
+	doc := loadOpenAPI(t)
+	for name, v := range map[string]interface{}{
+		"RenderRequest":        RenderRequest{},
+		"RenderOptions":        RenderOptions{},
+		"BatchRequest":         BatchRequest{},
+		"BatchItem":            BatchItem{},
+		"BatchResponse":        BatchResponse{},
+		"BatchResult":          BatchResult{},
+		"StreamResult":         StreamResult{},
+		"JobRequest":           JobRequest{},
+		"Job":                  Job{},
+		"LintResponse":         LintResponse{},
+		"Issue":                template.Issue{},
+		"TemplateInfo":         TemplateInfo{},
+		"TemplateResponse":     TemplateResponse{},
+		"TemplateListResponse": TemplateListResponse{},
+		"ReloadResult":         ReloadResult{},
+		"ReloadError":          ReloadError{},
+		"HttpErrorResponse":    HttpErrorResponse{},
+		"HttpError":            HttpError{},
+		"Violation":            schema.Violation{},
+		"HealthResponse":       HealthResponse{},
+		"VersionResponse":      VersionResponse{},
+	} {
+		properties := []string{}
+		for property := range doc.Components.Schemas[name]["properties"].(map[string]interface{}) {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		assert.Equal(t, jsonFields(reflect.TypeOf(v)), properties, name)
+	}
+
+	reasons := []interface{}{}
+	for reason := range reasonStatus {
+		reasons = append(reasons, reason)
+	}
+	httpError := doc.Components.Schemas["HttpError"]["properties"].(map[string]interface{})
+	assert.ElementsMatch(t, reasons, httpError["reason"].(map[string]interface{})["enum"])
+}
+
+// compileResponseSchema compiles a schema of the document, e.g. "#/components/schemas/BatchResponse"
+func compileResponseSchema(t *testing.T, doc openAPI, ref string) *schema.Schema {
+	wrapper, _ := json.Marshal(map[string]interface{}{"$ref": ref, "components": doc.Components})
+	s, err := schema.Compile(strings.TrimPrefix(ref, "#/components/schemas/"), wrapper)
+	if !assert.NoError(t, err, ref) {
+		t.FailNow()
+	}
+	return s
+}
+
+// TestOpenAPIDescribesHandlers sends requests to each operation of the document and checks
+// that the status of the response is documented and that its body conforms to the documented schema
+func TestOpenAPIDescribesHandlers(t *testing.T) {
+
+	templates = newRegistry()
+	templates.put("greeting", `Hi {{ .name }}`, sourceUpload)
+	allowUpload = true
+	// the queue is not started, so one job can be queued and the next one gets QueueFull
+	jobs, _ = newJobQueue("", 1, 1, time.Hour, 0)
+	jobs.jobs["done"] = &job{Job: Job{ID: "done", Status: JobSucceeded, ContentType: "text/plain; charset=utf-8"}, output: []byte("Hi a")}
+	jobs.jobs["waiting"] = &job{Job: Job{ID: "waiting", Status: JobQueued}}
+	defer func() {
+		templates = newRegistry()
+		allowUpload = false
+		jobs = nil
+		ready.Store(false)
+	}()
+	doc := loadOpenAPI(t)
+	mux := newMux("/gotmpl")
+
+	request := func(method, target, contentType, body string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		return r
+	}
+	tests := []struct {
+		request *http.Request
+		status  int
+	}{
+		{request(http.MethodPost, "/gotmpl", "application/json", `{"template": "{{ .a }}", "data": {"a": 1}}`), http.StatusOK},
+		{newFormRequest("/gotmpl", map[string]string{"template": "{{ .a }}", "data": "a: 1"}), http.StatusOK},
+		{request(http.MethodPost, "/gotmpl", "application/json", `{"tmpl": ""}`), http.StatusBadRequest},
+		{request(http.MethodPost, "/gotmpl", "application/json", `{"template": "{{ .a "}`), http.StatusUnprocessableEntity},
+		{request(http.MethodPost, "/render/greeting", "application/json", `{"data": {"name": "a"}}`), http.StatusOK},
+		{request(http.MethodPost, "/render/missing", "application/json", `{"data": {}}`), http.StatusNotFound},
+		{request(http.MethodPost, "/render/batch", "application/json", `{"name": "greeting", "data": [{"name": "a"}, "x"]}`), http.StatusOK},
+		{request(http.MethodPost, "/render/batch", "text/plain", ``), http.StatusBadRequest},
+		{request(http.MethodPost, "/render/stream?name=greeting", "application/x-ndjson", `{"name": "a"}`+"\n"+`x`), http.StatusOK},
+		{request(http.MethodPost, "/render/stream?template={{", "application/x-ndjson", ``), http.StatusUnprocessableEntity},
+		{request(http.MethodPost, "/jobs", "application/json", `{"name": "greeting", "data": {"name": "a"}}`), http.StatusAccepted},
+		{request(http.MethodPost, "/jobs", "application/json", `{"name": "greeting", "data": {"name": "b"}}`), http.StatusServiceUnavailable},
+		{request(http.MethodPost, "/jobs", "application/json", `{"template": "{{ .a "}`), http.StatusUnprocessableEntity},
+		{request(http.MethodGet, "/jobs/done", "", ""), http.StatusOK},
+		{request(http.MethodGet, "/jobs/missing", "", ""), http.StatusNotFound},
+		{request(http.MethodGet, "/jobs/done/result", "", ""), http.StatusOK},
+		{request(http.MethodGet, "/jobs/waiting/result", "", ""), http.StatusConflict},
+		{newFormRequest("/lint", map[string]string{"template": "{{ .a }}{{ foo }}"}), http.StatusOK},
+		{newFormRequest("/lint", map[string]string{}), http.StatusBadRequest},
+		{request(http.MethodGet, "/templates", "", ""), http.StatusOK},
+		{request(http.MethodGet, "/templates/greeting", "", ""), http.StatusOK},
+		{request(http.MethodGet, "/templates/missing", "", ""), http.StatusNotFound},
+		{request(http.MethodPut, "/templates/new", "text/plain", `{{ .a }}`), http.StatusCreated},
+		{request(http.MethodPut, "/templates/new", "text/plain", `{{ .b }}`), http.StatusOK},
+		{request(http.MethodPut, "/templates/new", "text/plain", `{{ .b `), http.StatusUnprocessableEntity},
+		{request(http.MethodGet, "/healthz", "", ""), http.StatusOK},
+		{request(http.MethodGet, "/readyz", "", ""), http.StatusServiceUnavailable},
+		{request(http.MethodGet, "/version", "", ""), http.StatusOK},
+		{request(http.MethodGet, "/metrics", "", ""), http.StatusOK},
+		{request(http.MethodGet, "/openapi.json", "", ""), http.StatusOK},
+	}
+
+	// the paths of the document as patterns, e.g. /templates/{name} matches /templates/greeting
+	patterns := map[string]*regexp.Regexp{}
+	for path := range doc.Paths {
+		patterns[path] = regexp.MustCompile("^" + regexp.MustCompile(`\\\{\w+\\\}`).ReplaceAllString(regexp.QuoteMeta(path), `[^/]+`) + "$")
+	}
+	operationPath := func(path string) string {
+		// prefer an exact match, e.g. /render/batch over /render/{name}
+		if _, ok := doc.Paths[path]; ok {
+			return path
+		}
+		for p, pattern := range patterns {
+			if pattern.MatchString(path) {
+				return p
+			}
+		}
+		return ""
+	}
+
+	tested := map[string]bool{}
+	for _, tt := range tests {
+		name := tt.request.Method + " " + tt.request.URL.Path
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, tt.request)
+		assert.Equal(t, tt.status, w.Code, name)
+
+		path := operationPath(tt.request.URL.Path)
+		if !assert.NotEmpty(t, path, name) {
+			continue
+		}
+		var operation openAPIOperation
+		if !assert.NoError(t, json.Unmarshal(doc.Paths[path][strings.ToLower(tt.request.Method)], &operation), name) {
+			continue
+		}
+		tested[strings.ToLower(tt.request.Method)+" "+path] = true
+
+		response, ok := operation.Responses[strconv.Itoa(w.Code)]
+		if !assert.True(t, ok, "%s: status %d is not documented", name, w.Code) {
+			continue
+		}
+		if response.Ref != "" {
+			response = doc.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+		}
+		mediaType := strings.Split(w.Header().Get("Content-Type"), ";")[0]
+		content, ok := response.Content[mediaType]
+		if !ok {
+			_, ok = response.Content["*/*"]
+			assert.True(t, ok, "%s: Content-Type %s is not documented", name, mediaType)
+			continue
+		}
+		if content.Schema.Ref == "" {
+			continue
+		}
+		s := compileResponseSchema(t, doc, content.Schema.Ref)
+		bodies := []string{w.Body.String()}
+		if mediaType == "application/x-ndjson" {
+			bodies = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		}
+		for _, body := range bodies {
+			var v interface{}
+			assert.NoError(t, json.Unmarshal([]byte(body), &v), name)
+			assert.NoError(t, s.Validate(v), name)
+		}
+	}
+
+	// every operation of the document must be tested
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if method != "parameters" {
+				assert.True(t, tested[method+" "+path], "%s %s is not tested", method, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/dataformat"
+)
+
+// Output types of rendered templates
+const (
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+	OutputXML  = "xml"
+	OutputHTML = "html"
+	OutputText = "text"
+)
+
+// outputContentTypes is the Content-Type of each output type
+var outputContentTypes = map[string]string{
+	OutputJSON: "application/json",
+	OutputYAML: "application/yaml",
+	OutputXML:  "application/xml",
+	OutputHTML: "text/html; charset=utf-8",
+	OutputText: "text/plain; charset=utf-8",
+}
+
+// outputExtensions are the file extensions (of a named template without .tmpl) which set its output type
+var outputExtensions = map[string]string{
+	".json": OutputJSON,
+	".yaml": OutputYAML,
+	".yml":  OutputYAML,
+	".xml":  OutputXML,
+	".html": OutputHTML,
+	".htm":  OutputHTML,
+	".txt":  OutputText,
+}
+
+// outputTypeFromName returns the output type of a named template based on its extension, e.g. "report.json", or empty if not known
+func outputTypeFromName(name string) string {
+	return outputExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// outputTypeComment matches a comment at the start of a named template which sets its output type, e.g. {{/* outputType: json */}}
+var outputTypeComment = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*outputType:\s*(.*?)\s*\*/\s*-?\}\}`)
+
+// templateOutputType returns the output type of a named template: the value of an outputType comment at the start of the
+// template if there is one, otherwise the type based on the extension of its name
+func templateOutputType(name string, text string) (string, error) {
+	match := outputTypeComment.FindStringSubmatch(text)
+	if match == nil {
+		return outputTypeFromName(name), nil
+	}
+	if _, err := outputContentType(match[1]); err != nil {
+		return "", err
+	}
+	return match[1], nil
+}
+
+// outputContentType returns the Content-Type for an output type, which is either one of the known types or a media type
+// such as "text/csv". The default for an empty output type is plain text.
+func outputContentType(outputType string) (string, error) {
+	if outputType == "" {
+		outputType = OutputText
+	}
+	if contentType, ok := outputContentTypes[outputType]; ok {
+		return contentType, nil
+	}
+	if strings.Contains(outputType, "/") {
+		if _, _, err := mime.ParseMediaType(outputType); err == nil {
+			return outputType, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported output type '%s' (must be one of json, yaml, xml, html, text or a media type)", outputType)
+}
+
+// outputFormat returns the data format (json, yaml or xml) of an output type given as a known type or a media type,
+// e.g. json for "application/json; charset=utf-8" or "application/ld+json", or empty if the output type is not a data format
+func outputFormat(outputType string) string {
+	switch outputType {
+	case OutputJSON, OutputYAML, OutputXML:
+		return outputType
+	}
+	mediaType, _, err := mime.ParseMediaType(outputType)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == "application/json", mediaType == "text/json", strings.HasSuffix(mediaType, "+json"):
+		return OutputJSON
+	case mediaType == "application/yaml", mediaType == "application/x-yaml", mediaType == "text/yaml", mediaType == "text/x-yaml",
+		strings.HasSuffix(mediaType, "+yaml"):
+		return OutputYAML
+	case mediaType == "application/xml", mediaType == "text/xml", strings.HasSuffix(mediaType, "+xml"):
+		return OutputXML
+	}
+	return ""
+}
+
+// validateOutput checks that the rendered output is well-formed for its output type, which must be json, yaml or xml
+// (or one of their media types)
+func validateOutput(outputType string, output []byte) error {
+	switch outputFormat(outputType) {
+	case OutputJSON:
+		return dataformat.Validate(dataformat.JSON, output)
+	case OutputYAML:
+		return dataformat.Validate(dataformat.YAML, output)
+	case OutputXML:
+		return dataformat.Validate(dataformat.XML, output)
+	}
+	return fmt.Errorf("output type '%s' can not be validated (must be json, yaml, xml or one of their media types)", outputType)
+}
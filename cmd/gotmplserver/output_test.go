@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputContentType(t *testing.T) {
+
+	tests := []struct {
+		outputType  string
+		contentType string
+		err         bool
+	}{
+		{outputType: "", contentType: "text/plain; charset=utf-8"},
+		{outputType: "json", contentType: "application/json"},
+		{outputType: "html", contentType: "text/html; charset=utf-8"},
+		{outputType: "text/csv", contentType: "text/csv"},
+		{outputType: "csv", err: true},
+	}
+
+	for _, tt := range tests {
+		contentType, err := outputContentType(tt.outputType)
+		if tt.err {
+			assert.Error(t, err, tt.outputType)
+			continue
+		}
+		assert.NoError(t, err, tt.outputType)
+		assert.Equal(t, tt.contentType, contentType, tt.outputType)
+	}
+
+	assert.Equal(t, OutputJSON, outputTypeFromName("reports/daily.json"))
+	assert.Equal(t, OutputYAML, outputTypeFromName("values.YML"))
+	assert.Equal(t, "", outputTypeFromName("greeting"))
+
+	for outputType, format := range map[string]string{
+		"yaml":                            OutputYAML,
+		"application/json; charset=utf-8": OutputJSON,
+		"application/ld+json":             OutputJSON,
+		"text/x-yaml":                     OutputYAML,
+		"application/atom+xml":            OutputXML,
+		"text/html":                       "",
+		"":                                "",
+	} {
+		assert.Equal(t, format, outputFormat(outputType), outputType)
+	}
+}
+
+func TestTemplateOutputType(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		text       string
+		outputType string
+		err        bool
+	}{
+		{name: "report.json", text: `{"a": 1}`, outputType: OutputJSON},
+		{name: "report", text: "{{/* outputType: application/ld+json */}}\n{}", outputType: "application/ld+json"},
+		{name: "report.txt", text: "{{- /* outputType: yaml */ -}}\na: 1", outputType: OutputYAML},
+		{name: "report", text: "a: 1\n{{/* outputType: yaml */}}", outputType: ""},
+		{name: "report", text: "{{/* outputType: csv */}}", err: true},
+	}
+
+	for _, tt := range tests {
+		outputType, err := templateOutputType(tt.name, tt.text)
+		if tt.err {
+			assert.Error(t, err, tt.text)
+			continue
+		}
+		assert.NoError(t, err, tt.text)
+		assert.Equal(t, tt.outputType, outputType, tt.text)
+	}
+}
+
+func TestRenderOutputType(t *testing.T) {
+
+	templates = newRegistry()
+	defer func() { templates = newRegistry() }()
+	templates.put("report.json", `{"a": "{{ .a }}"}`, sourceUpload)
+	templates.put("report", "{{/* outputType: application/json */ -}}\n"+`{"a": "{{ .a }}"}`, sourceUpload)
+
+	tests := []struct {
+		name        string
+		request     *http.Request
+		handler     http.HandlerFunc
+		status      int
+		contentType string
+		expect      string
+	}{{
+		name:        "default",
+		request:     newJSONRequest("/gotmpl", `{"template": "<b>{{ .a }}</b>", "data": {"a": "b"}}`),
+		handler:     handlePath,
+		status:      http.StatusOK,
+		contentType: "text/plain; charset=utf-8",
+	}, {
+		name:        "option",
+		request:     newJSONRequest("/gotmpl", `{"template": "<b>{{ .a }}</b>", "data": {"a": "b"}, "options": {"outputType": "html"}}`),
+		handler:     handlePath,
+		status:      http.StatusOK,
+		contentType: "text/html; charset=utf-8",
+	}, {
+		name:        "named template extension",
+		request:     newJSONRequest("/render/report.json", `{"data": {"a": "b"}, "options": {"validateOutput": true}}`),
+		handler:     handleRender,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		expect:      `{"a": "b"}`,
+	}, {
+		name:    "invalid output",
+		request: newJSONRequest("/render/report.json", `{"data": {"a": "\""}, "options": {"validateOutput": true}}`),
+		handler: handleRender,
+		status:  http.StatusUnprocessableEntity,
+		expect:  `"reason":"OutputValidationError","message":"invalid character '\"' after object key:value pair","line":1,"column":9}`,
+	}, {
+		name:        "named template comment",
+		request:     newJSONRequest("/render/report", `{"data": {"a": "b"}, "options": {"validateOutput": true}}`),
+		handler:     handleRender,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		expect:      `{"a": "b"}`,
+	}, {
+		name:    "invalid output of media type",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ .a }}", "data": {"a": "{"}, "options": {"outputType": "application/json", "validateOutput": true}}`),
+		handler: handlePath,
+		status:  http.StatusUnprocessableEntity,
+		expect:  `"reason":"OutputValidationError"`,
+	}, {
+		name:    "output type can not be validated",
+		request: newJSONRequest("/gotmpl", `{"template": "{{ .a }}", "data": {"a": "b"}, "options": {"outputType": "text/html", "validateOutput": true}}`),
+		handler: handlePath,
+		status:  http.StatusBadRequest,
+		expect:  `"reason":"InvalidOption","message":"validateOutput can not check output type 'text/html'`,
+	}, {
+		name:    "invalid output type",
+		request: newFormRequest("/gotmpl", map[string]string{"template": "{{ .a }}", "outputType": "csv"}),
+		handler: handlePath,
+		status:  http.StatusBadRequest,
+		expect:  `"reason":"InvalidOption"`,
+	}}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, tt.request)
+		assert.Equal(t, tt.status, w.Code, tt.name)
+		if tt.contentType != "" {
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"), tt.name)
+		}
+		assert.Contains(t, w.Body.String(), tt.expect, tt.name)
+	}
+}
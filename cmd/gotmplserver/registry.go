@@ -0,0 +1,513 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+// templateExtension is the file extension of templates in the --templates directory (removed from the template name)
+const templateExtension = ".tmpl"
+
+// reservedNames can not be used for named templates, since POST /render/{name} with these names is another endpoint
+var reservedNames = map[string]bool{"batch": true, "stream": true}
+
+// Sources of a template in the registry
+const (
+	sourceFile   = "file"
+	sourceUpload = "upload"
+)
+
+// TemplateInfo is the metadata of a template in the registry
+type TemplateInfo struct {
+	Name     string    `json:"name"`
+	Version  int       `json:"version"`
+	ETag     string    `json:"etag"`
+	Modified time.Time `json:"modified"`
+	Source   string    `json:"source"`
+	// OutputType is the output type of the template set by an outputType comment at its start, e.g. {{/* outputType: json */}},
+	// or otherwise based on the extension of its name, e.g. "report.json"
+	OutputType string `json:"outputType,omitempty"`
+}
+
+// TemplateListResponse is the response of GET /templates
+type TemplateListResponse struct {
+	Templates  []TemplateInfo `json:"templates"`
+	LastReload *ReloadResult  `json:"lastReload,omitempty"`
+}
+
+// TemplateResponse is the response of GET and PUT /templates/{name}
+type TemplateResponse struct {
+	TemplateInfo
+	Template string `json:"template"`
+}
+
+// registryTemplate is a compiled template together with its metadata
+type registryTemplate struct {
+	TemplateInfo
+	text     string
+	compiled *template.Template
+}
+
+// ReloadResult is the outcome of (re)loading the templates directory
+type ReloadResult struct {
+	Time      time.Time     `json:"time"`
+	Added     []string      `json:"added"`
+	Updated   []string      `json:"updated"`
+	Removed   []string      `json:"removed"`
+	Unchanged int           `json:"unchanged"`
+	Errors    []ReloadError `json:"errors"`
+}
+
+// ReloadError is a template (or the whole directory, if Name is empty) which could not be reloaded
+type ReloadError struct {
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// registry holds named templates which are compiled once and can then be rendered many times
+type registry struct {
+	mu         sync.RWMutex
+	templates  map[string]*registryTemplate
+	dirs       []string
+	lastReload *ReloadResult
+}
+
+// templates is the registry used by the server
+var templates = newRegistry()
+
+// allowUpload enables PUT /templates/{name}
+var allowUpload = false
+
+func newRegistry() *registry {
+	return &registry{templates: make(map[string]*registryTemplate)}
+}
+
+// etag returns a strong entity tag for the given template text
+func etag(text string) string {
+	return `"` + templateHash(text) + `"`
+}
+
+// templateHash returns a short hash of the template text which identifies it in logs
+func templateHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:16])
+}
+
+// loadDirs sets the templates directories of the registry and loads all templates from them.
+// Unlike a reload, it is an error if any of the templates can not be compiled.
+func (reg *registry) loadDirs(dirs ...string) error {
+	reg.mu.Lock()
+	reg.dirs = dirs
+	reg.mu.Unlock()
+
+	result := reg.reload()
+	if len(result.Errors) > 0 {
+		if result.Errors[0].Name == "" {
+			return fmt.Errorf("could not load templates: %s", result.Errors[0].Error)
+		}
+		return fmt.Errorf("could not compile template '%s': %s", result.Errors[0].Name, result.Errors[0].Error)
+	}
+	return nil
+}
+
+// readDirs reads all template files in each of dirs (see readDir); a name must only be used in one of the directories
+func readDirs(dirs []string) (map[string]string, error) {
+	texts := make(map[string]string)
+	found := make(map[string]string)
+	for _, dir := range dirs {
+		dirTexts, err := readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for name, text := range dirTexts {
+			if other, ok := found[name]; ok {
+				return nil, fmt.Errorf("template '%s' is in both '%s' and '%s'", name, other, dir)
+			}
+			found[name] = dir
+			texts[name] = text
+		}
+	}
+	return texts, nil
+}
+
+// readDir reads all template files in dir (including subdirectories) and returns their text by name.
+// The name of each template is its path relative to dir, using "/" as separator and without the ".tmpl" extension.
+func readDir(dir string) (map[string]string, error) {
+	texts := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip hidden files and directories, e.g. the "..data" directories of Kubernetes ConfigMap volumes
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || filepath.Ext(path) != templateExtension {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		texts[strings.TrimSuffix(filepath.ToSlash(rel), templateExtension)] = string(text)
+		return nil
+	})
+	return texts, err
+}
+
+// reload reads the templates directories and recompiles any templates which have changed. All changes are
+// applied at the same time once everything has been compiled, and a template which can not be compiled keeps
+// its previous version. Templates whose files have been removed are removed, but uploaded templates are kept,
+// even if a file with the same name has been added since (which is reported as an error).
+func (reg *registry) reload() ReloadResult {
+
+	reg.mu.RLock()
+	dirs := reg.dirs
+	reg.mu.RUnlock()
+
+	result := ReloadResult{Time: time.Now().UTC(), Added: []string{}, Updated: []string{}, Removed: []string{}, Errors: []ReloadError{}}
+	texts, err := readDirs(dirs)
+	if err != nil {
+		result.Errors = append(result.Errors, ReloadError{Error: err.Error()})
+		reg.setLastReload(result)
+		return result
+	}
+
+	names := make([]string, 0, len(texts))
+	for name := range texts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changed := []*registryTemplate{}
+	for _, name := range names {
+		existing, exists := reg.get(name)
+		if exists && existing.Source == sourceUpload {
+			result.Errors = append(result.Errors, ReloadError{Name: name, Error: "a template with the same name has been uploaded"})
+			continue
+		}
+		if exists && existing.ETag == etag(texts[name]) {
+			result.Unchanged++
+			continue
+		}
+		rt, err := newRegistryTemplate(name, texts[name], sourceFile, defaultProfile)
+		if err != nil {
+			result.Errors = append(result.Errors, ReloadError{Name: name, Error: err.Error()})
+			continue
+		}
+		changed = append(changed, rt)
+		if exists {
+			result.Updated = append(result.Updated, name)
+		} else {
+			result.Added = append(result.Added, name)
+		}
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, rt := range changed {
+		rt.replaces(reg.templates[rt.Name])
+		reg.templates[rt.Name] = rt
+	}
+	for name, rt := range reg.templates {
+		if _, ok := texts[name]; !ok && rt.Source == sourceFile {
+			delete(reg.templates, name)
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	sort.Strings(result.Removed)
+	reg.lastReload = &result
+	return result
+}
+
+func (reg *registry) setLastReload(result ReloadResult) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.lastReload = &result
+}
+
+// put compiles and adds (or replaces) a template; the version is only incremented if the text has changed
+func (reg *registry) put(name string, text string, source string) (*registryTemplate, error) {
+	return reg.putProfile(name, text, source, defaultProfile)
+}
+
+// putProfile is put with the function profile which the template is compiled with, e.g. the profile of the client which uploaded it
+func (reg *registry) putProfile(name string, text string, source string, profile template.Profile) (*registryTemplate, error) {
+
+	rt, err := newRegistryTemplate(name, text, source, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	rt.replaces(reg.templates[name])
+	reg.templates[name] = rt
+	return rt, nil
+}
+
+// newRegistryTemplate compiles a template with the given function profile as version 1 and reads its output type
+func newRegistryTemplate(name string, text string, source string, profile template.Profile) (*registryTemplate, error) {
+
+	if reservedNames[name] {
+		return nil, fmt.Errorf("the name '%s' is reserved for POST /render/%s and can not be used for a template", name, name)
+	}
+	engine := newEngine()
+	engine.Profile = profile
+	compiled, err := engine.Compile(text)
+	if err != nil {
+		return nil, err
+	}
+	outputType, err := templateOutputType(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &registryTemplate{
+		TemplateInfo: TemplateInfo{
+			Name:       name,
+			Version:    1,
+			ETag:       etag(text),
+			Modified:   time.Now().UTC(),
+			Source:     source,
+			OutputType: outputType,
+		},
+		text:     text,
+		compiled: compiled,
+	}
+	return rt, nil
+}
+
+// replaces sets the version of rt to follow the existing template (if any) which it replaces;
+// the version is only incremented if the text has changed
+func (rt *registryTemplate) replaces(existing *registryTemplate) {
+	if existing == nil {
+		return
+	}
+	rt.Version = existing.Version + 1
+	if existing.ETag == rt.ETag {
+		rt.Version, rt.Modified = existing.Version, existing.Modified
+	}
+}
+
+// get returns the named template
+func (reg *registry) get(name string) (*registryTemplate, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	rt, ok := reg.templates[name]
+	return rt, ok
+}
+
+// list returns the metadata of all templates sorted by name
+func (reg *registry) list() []TemplateInfo {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	infos := make([]TemplateInfo, 0, len(reg.templates))
+	for _, rt := range reg.templates {
+		infos = append(infos, rt.TemplateInfo)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// handleTemplates handles GET /templates, GET /templates/{name} and PUT /templates/{name}
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/templates"), "/")
+	client := authClient(r)
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Add("Allow", "GET")
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		templates.mu.RLock()
+		lastReload := templates.lastReload
+		templates.mu.RUnlock()
+		// only list the templates which the client may use
+		list := []TemplateInfo{}
+		for _, info := range templates.list() {
+			if client.allowsTemplate(info.Name) {
+				list = append(list, info)
+			}
+		}
+		writeJson(w, http.StatusOK, TemplateListResponse{Templates: list, LastReload: lastReload})
+		return
+	}
+	if !client.allowsTemplate(name) {
+		writeHttpError(w, *templateForbidden(name))
+		return
+	}
+
+	switch r.Method {
+
+	case http.MethodGet:
+		rt, ok := templates.get(name)
+		if !ok {
+			writeHttpError(w, HttpError{Reason: ReasonTemplateNotFound, Message: fmt.Sprintf("template '%s' not found", name)})
+			return
+		}
+		w.Header().Set("ETag", rt.ETag)
+		writeJson(w, http.StatusOK, TemplateResponse{rt.TemplateInfo, rt.text})
+
+	case http.MethodPut:
+		if !allowUpload {
+			writeHttpError(w, HttpError{Reason: ReasonUploadNotAllowed, Message: "template uploads are not enabled on this server"})
+			return
+		}
+		// uploading a template is sending its own template, so a client which may only use named templates may not upload them
+		if !client.allowsInline() {
+			writeHttpError(w, *inlineForbidden())
+			return
+		}
+
+		if reservedNames[name] {
+			writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: fmt.Sprintf("the name '%s' is reserved for POST /render/%s and can not be used for a template", name, name)})
+			return
+		}
+
+		// If-Match can be used to make sure that a template is not replaced if someone else has changed it
+		existing, exists := templates.get(name)
+		if exists && existing.Source == sourceFile {
+			writeHttpError(w, HttpError{Reason: ReasonForbidden, Message: fmt.Sprintf("template '%s' is loaded from the templates directory and can not be replaced by an upload", name)})
+			return
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!exists || (ifMatch != "*" && ifMatch != existing.ETag)) {
+			writeHttpError(w, HttpError{Reason: ReasonPreconditionFailed, Message: fmt.Sprintf("template '%s' does not match If-Match %s", name, ifMatch)})
+			return
+		}
+
+		text, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		if httpErr := templateSizeError(name, string(text)); httpErr != nil {
+			writeHttpError(w, *httpErr)
+			return
+		}
+		rt, err := templates.putProfile(name, string(text), sourceUpload, client.profile(defaultProfile))
+		if err != nil {
+			writeHttpError(w, HttpError{Reason: ReasonTemplateError, Message: err.Error()})
+			return
+		}
+
+		status := http.StatusOK
+		if !exists {
+			status = http.StatusCreated
+		}
+		w.Header().Set("ETag", rt.ETag)
+		writeJson(w, status, TemplateResponse{rt.TemplateInfo, rt.text})
+
+	default:
+		allow := "GET"
+		if allowUpload {
+			allow += ", PUT"
+		}
+		w.Header().Add("Allow", allow)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRender handles POST /render/{name} which renders a template from the registry; the request is
+// the same as for the main path (form values or JSON body) except that it only contains the data and options
+func handleRender(w http.ResponseWriter, r *http.Request) {
+
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		w.Header().Add("Allow", "POST")
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/render"), "/")
+	rt, httpErr := lookupTemplate(authClient(r), name)
+	if httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
+	setTemplateName(w, name)
+	setTemplateHash(w, templateHash(rt.text))
+
+	req, err := readRenderRequest(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if req.Template != "" {
+		writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: "template must not be sent when rendering a named template"})
+		return
+	}
+	tmpl, httpErr := rt.withOptions(&req.Options)
+	if httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
+
+	w.Header().Set("X-Gotmpl-Template-Version", fmt.Sprint(rt.Version))
+	w.Header().Set("X-Gotmpl-Template-ETag", rt.ETag)
+	renderTemplate(w, r, req, tmpl)
+}
+
+// lookupTemplate returns the named template if it exists and the client may use it
+func lookupTemplate(client *AuthClient, name string) (*registryTemplate, *HttpError) {
+	if !client.allowsTemplate(name) {
+		return nil, templateForbidden(name)
+	}
+	rt, ok := templates.get(name)
+	if !ok {
+		return nil, &HttpError{Reason: ReasonTemplateNotFound, Message: fmt.Sprintf("template '%s' not found", name)}
+	}
+	return rt, nil
+}
+
+// withOptions returns the compiled template with the missing key policy of the options (if set),
+// and sets the output type of the options to the output type of the template if it is not set
+func (rt *registryTemplate) withOptions(o *RenderOptions) (*template.Template, *HttpError) {
+	if len(o.Delimiters) > 0 {
+		return nil, &HttpError{Reason: ReasonInvalidOption, Message: "delimiters can not be changed for a named template"}
+	}
+	if o.OutputType == "" {
+		o.OutputType = rt.OutputType
+	}
+	if o.MissingKey == "" {
+		return rt.compiled, nil
+	}
+	missingKey, err := template.ParseMissingKey(o.MissingKey)
+	if err != nil {
+		return nil, &HttpError{Reason: ReasonInvalidOption, Message: err.Error()}
+	}
+	tmpl, err := rt.compiled.WithMissingKey(missingKey)
+	if err != nil {
+		return nil, &HttpError{Reason: ReasonTemplateError, Message: err.Error()}
+	}
+	return tmpl, nil
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	responseBytes, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseBytes)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryLoadDir(t *testing.T) {
+
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "test.tmpl"), []byte(`{{ .Data.aKey }}`), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "other.json.tmpl"), []byte(`{"a": "{{ .a }}"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "readme.md"), []byte(`not a template`), 0644)
+
+	reg := newRegistry()
+	assert.NoError(t, reg.loadDirs(dir))
+
+	names := []string{}
+	for _, info := range reg.list() {
+		names = append(names, info.Name)
+		assert.Equal(t, 1, info.Version)
+		assert.Equal(t, sourceFile, info.Source)
+	}
+	assert.Equal(t, []string{"sub/other.json", "test"}, names)
+
+	// templates can be loaded from several directories, but a name must only be used once
+	other := t.TempDir()
+	os.WriteFile(filepath.Join(other, "another.tmpl"), []byte(`another`), 0644)
+	reg = newRegistry()
+	assert.NoError(t, reg.loadDirs(dir, other))
+	assert.Len(t, reg.list(), 3)
+	os.WriteFile(filepath.Join(other, "test.tmpl"), []byte(`test`), 0644)
+	assert.ErrorContains(t, newRegistry().loadDirs(dir, other), "template 'test' is in both")
+
+	os.WriteFile(filepath.Join(dir, "bad.tmpl"), []byte(`{{ .a `), 0644)
+	assert.Error(t, newRegistry().loadDirs(dir))
+}
+
+func TestRegistryPut(t *testing.T) {
+
+	reg := newRegistry()
+
+	rt, err := reg.put("test", `{{ .a }}`, sourceUpload)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rt.Version)
+	etag1 := rt.ETag
+
+	// same text keeps the version
+	rt, err = reg.put("test", `{{ .a }}`, sourceUpload)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rt.Version)
+	assert.Equal(t, etag1, rt.ETag)
+
+	rt, err = reg.put("test", `{{ .b }}`, sourceUpload)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rt.Version)
+	assert.NotEqual(t, etag1, rt.ETag)
+
+	// a template which does not compile does not replace the existing one
+	_, err = reg.put("test", `{{ .b `, sourceUpload)
+	assert.Error(t, err)
+	rt, _ = reg.get("test")
+	assert.Equal(t, 2, rt.Version)
+}
+
+func TestHandleTemplatesAndRender(t *testing.T) {
+
+	templates = newRegistry()
+	allowUpload = true
+	defer func() {
+		templates = newRegistry()
+		allowUpload = false
+	}()
+
+	put := func(name, body, ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/templates/"+name, strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		handleTemplates(w, r)
+		return w
+	}
+
+	w := put("greeting", `Hello {{ .name }}`, "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	etag1 := w.Header().Get("ETag")
+
+	w = put("greeting", `Hi {{ .name }}`, `"somethingelse"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = put("greeting", `Hi {{ .name }}`, etag1)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"version":2`)
+
+	w = put("broken", `{{ .name `, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"TemplateError"`)
+
+	w = httptest.NewRecorder()
+	handleTemplates(w, httptest.NewRequest(http.MethodGet, "/templates", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"greeting"`)
+	assert.NotContains(t, w.Body.String(), `"name":"broken"`)
+
+	w = httptest.NewRecorder()
+	handleRender(w, newJSONRequest("/render/greeting", `{"data": {"name": "World"}}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hi World", w.Body.String())
+	assert.Equal(t, "2", w.Header().Get("X-Gotmpl-Template-Version"))
+
+	w = httptest.NewRecorder()
+	handleRender(w, newFormRequest("/render/greeting", map[string]string{"data": "{}", "missingkey": "zero"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hi <no value>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handleRender(w, newJSONRequest("/render/greeting", `{"template": "{{ . }}", "data": {}}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handleRender(w, newJSONRequest("/render/missing", `{"data": {}}`))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"TemplateNotFound"`)
+
+	// a template from the templates directory can not be replaced by an upload
+	templates.put("fromfile", `file`, sourceFile)
+	w = put("fromfile", `upload`, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	rt, _ := templates.get("fromfile")
+	assert.Equal(t, sourceFile, rt.Source)
+
+	// the names of other /render endpoints can not be used for templates
+	w = put("batch", `batch`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"reason":"RequestError"`)
+	_, ok := templates.get("batch")
+	assert.False(t, ok)
+
+	allowUpload = false
+	w = put("greeting", `Hello`, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRegistryReload(t *testing.T) {
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.tmpl"), []byte(`a {{ .a }}`), 0644)
+	os.WriteFile(filepath.Join(dir, "b.tmpl"), []byte(`b {{ .b }}`), 0644)
+	os.MkdirAll(filepath.Join(dir, "..data"), 0755)
+	os.WriteFile(filepath.Join(dir, "..data", "hidden.tmpl"), []byte(`hidden`), 0644)
+
+	reg := newRegistry()
+	assert.NoError(t, reg.loadDirs(dir))
+	assert.Len(t, reg.list(), 2)
+	reg.put("uploaded", `u`, sourceUpload)
+
+	os.WriteFile(filepath.Join(dir, "a.tmpl"), []byte(`a {{ .a `), 0644)
+	os.WriteFile(filepath.Join(dir, "b.tmpl"), []byte(`B {{ .b }}`), 0644)
+	os.WriteFile(filepath.Join(dir, "c.tmpl"), []byte(`c`), 0644)
+
+	result := reg.reload()
+	assert.Equal(t, []string{"c"}, result.Added)
+	assert.Equal(t, []string{"b"}, result.Updated)
+	assert.Equal(t, []string{}, result.Removed)
+	assert.Equal(t, 0, result.Unchanged)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, "a", result.Errors[0].Name)
+	}
+
+	// a failed to compile so the previous version is still used
+	a, _ := reg.get("a")
+	assert.Equal(t, 1, a.Version)
+	assert.Equal(t, `a {{ .a }}`, a.text)
+	b, _ := reg.get("b")
+	assert.Equal(t, 2, b.Version)
+
+	os.Remove(filepath.Join(dir, "a.tmpl"))
+	result = reg.reload()
+	assert.Equal(t, []string{"a"}, result.Removed)
+	assert.Equal(t, 2, result.Unchanged)
+	assert.Empty(t, result.Errors)
+	_, ok := reg.get("a")
+	assert.False(t, ok)
+	_, ok = reg.get("uploaded")
+	assert.True(t, ok)
+
+	// an uploaded template is kept when a file with the same name is added
+	os.WriteFile(filepath.Join(dir, "uploaded.tmpl"), []byte(`file`), 0644)
+	result = reg.reload()
+	assert.Empty(t, result.Added)
+	assert.Empty(t, result.Updated)
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, "uploaded", result.Errors[0].Name)
+	}
+	uploaded, _ := reg.get("uploaded")
+	assert.Equal(t, sourceUpload, uploaded.Source)
+	assert.Equal(t, `u`, uploaded.text)
+	os.Remove(filepath.Join(dir, "uploaded.tmpl"))
+
+	os.WriteFile(filepath.Join(dir, "stream.tmpl"), []byte(`stream`), 0644)
+	result = reg.reload()
+	if assert.Len(t, result.Errors, 1) {
+		assert.Equal(t, "stream", result.Errors[0].Name)
+	}
+	_, ok = reg.get("stream")
+	assert.False(t, ok)
+	os.Remove(filepath.Join(dir, "stream.tmpl"))
+
+	os.RemoveAll(dir)
+	result = reg.reload()
+	assert.Len(t, result.Errors, 1)
+	assert.Len(t, reg.list(), 3)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchTemplates reloads the templates directories whenever the process receives SIGHUP and,
+// if interval is greater than 0, also checks for changes every interval
+func watchTemplates(interval time.Duration) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// errors from the initial load are not possible since the server would not have started
+	lastErrors := ""
+	for {
+		select {
+		case <-hup:
+			result := templates.reload()
+			logReload("SIGHUP", result)
+			lastErrors = errorsString(result)
+		case <-tick:
+			// only log a periodic reload if something changed, so that the same errors are not logged over and over
+			result := templates.reload()
+			errors := errorsString(result)
+			if len(result.Added)+len(result.Updated)+len(result.Removed) > 0 || errors != lastErrors {
+				logReload("watch", result)
+			}
+			lastErrors = errors
+		}
+	}
+}
+
+// errorsString returns the errors of a reload as a string which can be compared with a previous reload
+func errorsString(result ReloadResult) string {
+	if len(result.Errors) == 0 {
+		return ""
+	}
+	return fmt.Sprint(result.Errors)
+}
+
+func logReload(trigger string, result ReloadResult) {
+	log.Printf("Reloaded templates (%s): %d added, %d updated, %d removed, %d unchanged, %d failed\n",
+		trigger, len(result.Added), len(result.Updated), len(result.Removed), result.Unchanged, len(result.Errors))
+	for _, e := range result.Errors {
+		if e.Name == "" {
+			log.Printf("Could not reload templates: %s\n", e.Error)
+			continue
+		}
+		log.Printf("Could not reload template '%s' (keeping previous version): %s\n", e.Name, e.Error)
+	}
+}
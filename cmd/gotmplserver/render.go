@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+// renderTimeout is the maximum duration of executing a template (0 means no limit)
+var renderTimeout time.Duration
+
+// renderTimeoutKey is the context key of a render timeout which replaces renderTimeout
+type renderTimeoutKey struct{}
+
+// withRenderTimeout returns a context in which executeTemplate uses timeout instead of renderTimeout (0 means no limit)
+func withRenderTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, renderTimeoutKey{}, timeout)
+}
+
+// errRenderTimeout is returned when executing a template takes longer than renderTimeout
+var errRenderTimeout = errors.New("rendering the template took too long")
+
+// renderSlots limits the number of templates which are executed at the same time (no limit if nil). A slot is held
+// until the execution has actually finished, which can be long after a render timeout, since a template can not be
+// stopped until it writes its next output; so templates which keep running after their timeout use up the slots
+// instead of adding to the load of the server without limit.
+var renderSlots chan struct{}
+
+// errRenderBusy is returned when all renderSlots are in use
+var errRenderBusy = errors.New("too many templates are being rendered; try again later")
+
+// renderWaitKey is the context key which makes executeTemplate wait for a free render slot instead of returning errRenderBusy
+type renderWaitKey struct{}
+
+// withRenderWait returns a context in which executeTemplate waits for a free render slot until ctx is done,
+// e.g. for the items of a batch or a job which should not fail just because the server is busy
+func withRenderWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, renderWaitKey{}, true)
+}
+
+// acquireRenderSlot takes one of the renderSlots, waiting for one if ctx was created by withRenderWait,
+// and returns the function which releases it; nothing is rendered once ctx is done
+func acquireRenderSlot(ctx context.Context) (func(), error) {
+	if ctx.Err() != nil {
+		return nil, errRenderTimeout
+	}
+	slots := renderSlots
+	if slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-slots }
+	if wait, _ := ctx.Value(renderWaitKey{}).(bool); wait {
+		select {
+		case slots <- struct{}{}:
+			return release, nil
+		case <-ctx.Done():
+			return nil, errRenderTimeout
+		}
+	}
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+		return nil, errRenderBusy
+	}
+}
+
+// panicError is returned when executing a template panics
+type panicError struct {
+	value interface{}
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("unexpected error while rendering: %v", e.value)
+}
+
+// contextWriter is a buffer which returns an error once its context is done, which stops
+// the execution of a template the next time it writes any output
+type contextWriter struct {
+	ctx context.Context
+	buf bytes.Buffer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.buf.Write(p)
+}
+
+// executeTemplate renders the template into a buffer so that nothing is written to the response
+// unless rendering succeeds. It returns errRenderTimeout if ctx is done or the render timeout has passed first,
+// and errRenderBusy if all renderSlots are in use.
+//
+// A timeout only stops waiting for the result: the execution itself continues until the template writes its next
+// output (or finishes), and keeps its render slot until then.
+func executeTemplate(ctx context.Context, tmpl *template.Template, data interface{}) ([]byte, error) {
+
+	release, err := acquireRenderSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := renderTimeout
+	if t, ok := ctx.Value(renderTimeoutKey{}).(time.Duration); ok {
+		timeout = t
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	out := &contextWriter{ctx: ctx}
+	done := make(chan error, 1)
+	go func() {
+		defer release()
+		defer func() {
+			if r := recover(); r != nil {
+				done <- panicError{r}
+			}
+		}()
+		done <- tmpl.Execute(data, out)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return out.buf.Bytes(), nil
+	case <-ctx.Done():
+		// the execution stops by itself the next time the template writes any output, and only then releases its slot
+		return nil, errRenderTimeout
+	}
+}
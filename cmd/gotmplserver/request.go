@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/dataformat"
+	"github.com/joshuagrisham-karolinska/gotmpl/template"
+)
+
+// RenderRequest is the body of a render request sent as application/json
+type RenderRequest struct {
+	// Template is the template text
+	Template string `json:"template"`
+	// Data is either a JSON object or a string containing data in the given (or guessed) Options.Format
+	Data json.RawMessage `json:"data"`
+	// Options are optional settings for this render
+	Options RenderOptions `json:"options"`
+}
+
+// RenderOptions are the optional settings of a render request
+type RenderOptions struct {
+	// Format is the format of the data: json, yaml or xml (guessed from the data if empty)
+	Format string `json:"format,omitempty"`
+	// MissingKey is the missing key policy: error, zero, default or invalid (server default if empty)
+	MissingKey string `json:"missingKey,omitempty"`
+	// ReportMissing adds the keys which were missing from the data in the X-Gotmpl-Missing-Keys response header
+	ReportMissing bool `json:"reportMissing,omitempty"`
+	// Delimiters are the left and right action delimiters, e.g. ["[[", "]]"] (default: "{{" and "}}")
+	Delimiters []string `json:"delimiters,omitempty"`
+	// SchemaName is the name of a schema loaded from the --schemas directory to validate the data against
+	SchemaName string `json:"schemaName,omitempty"`
+	// Schema is a JSON Schema document to validate the data against
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// OutputType sets the Content-Type of the output: json, yaml, xml, html, text or a media type
+	// (default: the type of a named template, otherwise text)
+	OutputType string `json:"outputType,omitempty"`
+	// ValidateOutput checks that json, yaml or xml output (or one of their media types) is well-formed before it is returned
+	ValidateOutput bool `json:"validateOutput,omitempty"`
+}
+
+// readRenderRequest reads a render request from either an application/json body or from form values.
+//
+// Note: per https://pkg.go.dev/net/http#Request.FormValue
+// using FormValue supports the client to set these values in any of:
+//   - application/x-www-form-urlencoded
+//   - query parameters
+//   - multipart/form-data
+func readRenderRequest(r *http.Request) (RenderRequest, error) {
+
+	var req RenderRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			return req, fmt.Errorf("could not read JSON request body: %w", err)
+		}
+		return req, nil
+	}
+
+	// parse the form first since FormValue ignores errors (e.g. if the body is too large)
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		return req, fmt.Errorf("could not read form: %w", err)
+	}
+	req.Template = r.FormValue("template")
+	req.Options = RenderOptions{
+		Format:         r.FormValue("format"),
+		MissingKey:     r.FormValue("missingkey"),
+		ReportMissing:  r.FormValue("reportMissing") == "true",
+		Delimiters:     strings.Fields(r.FormValue("delimiters")),
+		SchemaName:     r.FormValue("schemaName"),
+		OutputType:     r.FormValue("outputType"),
+		ValidateOutput: r.FormValue("validateOutput") == "true",
+	}
+	if data := r.FormValue("data"); data != "" {
+		// form data is always sent as text so quote it like a JSON string
+		req.Data, _ = json.Marshal(data)
+	}
+	if schema := strings.TrimSpace(r.FormValue("schema")); schema != "" {
+		req.Options.Schema = json.RawMessage(schema)
+	}
+	return req, nil
+}
+
+// data returns the unmarshalled data of the request; a JSON string is unmarshalled
+// using the requested (or guessed) format, and anything else must be a JSON object
+func (req RenderRequest) data() (map[string]interface{}, error) {
+
+	raw := bytes.TrimSpace(req.Data)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return make(map[string]interface{}), nil
+	}
+
+	if raw[0] == '"' {
+		var dataString string
+		if err := json.Unmarshal(raw, &dataString); err != nil {
+			return nil, err
+		}
+		return dataformat.Unmarshal(req.Options.Format, []byte(dataString))
+	}
+
+	if req.Options.Format != "" && req.Options.Format != dataformat.JSON {
+		return nil, fmt.Errorf("data in format '%s' must be sent as a string", req.Options.Format)
+	}
+	if raw[0] != '{' {
+		return nil, fmt.Errorf("data must be a JSON object or a string")
+	}
+	return dataformat.Unmarshal(dataformat.JSON, raw)
+}
+
+// dataFormat returns the format of the data: the requested format, json for a JSON object, or otherwise guessed from the data
+func (req RenderRequest) dataFormat() string {
+	if req.Options.Format != "" {
+		return req.Options.Format
+	}
+	raw := bytes.TrimSpace(req.Data)
+	if len(raw) == 0 || raw[0] != '"' {
+		return dataformat.JSON
+	}
+	var dataString string
+	json.Unmarshal(raw, &dataString)
+	return dataformat.Guess([]byte(dataString))
+}
+
+// engine returns a template Engine configured with the options of the request
+func (o RenderOptions) engine() (template.Engine, error) {
+
+	engine := newEngine()
+	if o.MissingKey != "" {
+		missingKey, err := template.ParseMissingKey(o.MissingKey)
+		if err != nil {
+			return engine, err
+		}
+		engine.MissingKey = missingKey
+	}
+
+	switch len(o.Delimiters) {
+	case 0:
+	case 2:
+		engine.LeftDelim, engine.RightDelim = o.Delimiters[0], o.Delimiters[1]
+	default:
+		return engine, fmt.Errorf("delimiters must be exactly two values (left and right) but got %d", len(o.Delimiters))
+	}
+
+	return engine, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joshuagrisham-karolinska/gotmpl/schema"
+)
+
+// schemas holds the JSON Schemas loaded from the --schemas directory, by name (file name without ".json")
+var schemas = map[string]*schema.Schema{}
+
+// loadSchemas compiles all *.json files in dir and adds them to schemas
+func loadSchemas(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		schemaBytes, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		s, err := schema.Compile(filepath.Base(file), schemaBytes)
+		if err != nil {
+			return fmt.Errorf("could not compile schema '%s': %w", file, err)
+		}
+		schemas[name] = s
+	}
+	return nil
+}
+
+// requestSchema returns the schema which should be used to validate the data of the request, if any:
+//   - SchemaName refers to one of the schemas loaded at startup
+//   - Schema is a JSON Schema document sent with the request
+func requestSchema(o RenderOptions) (*schema.Schema, error) {
+	if o.SchemaName != "" {
+		s, ok := schemas[o.SchemaName]
+		if !ok {
+			return nil, fmt.Errorf("schema '%s' not found", o.SchemaName)
+		}
+		return s, nil
+	}
+	if len(o.Schema) > 0 {
+		return schema.Compile("schema", o.Schema)
+	}
+	return nil, nil
+}
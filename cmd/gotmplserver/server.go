@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docopt/docopt-go"
+)
+
+// serverOptions are the limits and timeouts of the HTTP server
+type serverOptions struct {
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	maxHeaderBytes  int
+	maxBodyBytes    int64
+}
+
+// parseServerOptions reads the server options from the parsed command line options
+func parseServerOptions(opts docopt.Opts) (serverOptions, error) {
+	var so serverOptions
+	var err error
+	for name, d := range map[string]*time.Duration{
+		"--read-timeout":        &so.readTimeout,
+		"--write-timeout":       &so.writeTimeout,
+		"--idle-timeout":        &so.idleTimeout,
+		"--shutdown-timeout":    &so.shutdownTimeout,
+		"--render-timeout":      &renderTimeout,
+		"--stream-idle-timeout": &streamIdleTimeout,
+	} {
+		value, _ := opts.String(name)
+		if *d, err = time.ParseDuration(value); err != nil {
+			return so, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	batchTimeout = so.writeTimeout - so.writeTimeout/10
+	value, _ := opts.String("--max-header-bytes")
+	if so.maxHeaderBytes, err = strconv.Atoi(value); err != nil {
+		return so, fmt.Errorf("invalid --max-header-bytes: %w", err)
+	}
+	value, _ = opts.String("--max-body-bytes")
+	if so.maxBodyBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+		return so, fmt.Errorf("invalid --max-body-bytes: %w", err)
+	}
+	return so, nil
+}
+
+// parseLimits sets the template and data size limits, the render limit and the rate limiters from the parsed command line options
+func parseLimits(opts docopt.Opts) error {
+	var err error
+	value, _ := opts.String("--max-template-bytes")
+	if maxTemplateBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+		return fmt.Errorf("invalid --max-template-bytes: %w", err)
+	}
+	value, _ = opts.String("--max-data-bytes")
+	if maxDataBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+		return fmt.Errorf("invalid --max-data-bytes: %w", err)
+	}
+	value, _ = opts.String("--batch-workers")
+	if batchWorkers, err = strconv.Atoi(value); err != nil || batchWorkers < 1 {
+		return fmt.Errorf("invalid --batch-workers '%s'", value)
+	}
+	value, _ = opts.String("--max-batch-items")
+	if maxBatchItems, err = strconv.Atoi(value); err != nil {
+		return fmt.Errorf("invalid --max-batch-items: %w", err)
+	}
+	value, _ = opts.String("--max-renders")
+	maxRenders, err := strconv.Atoi(value)
+	if err != nil || maxRenders < 0 {
+		return fmt.Errorf("invalid --max-renders '%s'", value)
+	}
+	renderSlots = nil
+	if maxRenders > 0 {
+		renderSlots = make(chan struct{}, maxRenders)
+	}
+	value, _ = opts.String("--rate-limit")
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 {
+		return fmt.Errorf("invalid --rate-limit '%s'", value)
+	}
+	value, _ = opts.String("--rate-burst")
+	burst, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid --rate-burst: %w", err)
+	}
+	if rate > 0 {
+		limiter = newRateLimiter(rate, burst)
+	}
+	value, _ = opts.String("--auth-failure-limit")
+	failureRate, err := strconv.ParseFloat(value, 64)
+	if err != nil || failureRate < 0 {
+		return fmt.Errorf("invalid --auth-failure-limit '%s'", value)
+	}
+	value, _ = opts.String("--auth-failure-burst")
+	failureBurst, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid --auth-failure-burst: %w", err)
+	}
+	if failureRate > 0 {
+		authFailureLimiter = newRateLimiter(failureRate, failureBurst)
+	}
+	return nil
+}
+
+// newServer returns an HTTP server for handler which uses the given options
+func newServer(handler http.Handler, opts serverOptions) *http.Server {
+	return &http.Server{
+		Handler:           limitBody(handler, opts.maxBodyBytes),
+		ReadTimeout:       opts.readTimeout,
+		ReadHeaderTimeout: opts.readTimeout,
+		WriteTimeout:      opts.writeTimeout,
+		IdleTimeout:       opts.idleTimeout,
+		MaxHeaderBytes:    opts.maxHeaderBytes,
+	}
+}
+
+// listen listens on address, which is either host:port (where host can be empty for all interfaces) or unix:/path/to.sock;
+// a Unix domain socket gets the given permissions, and replaces a stale socket file left behind by a previous server
+func listen(address string, socketMode os.FileMode) (net.Listener, error) {
+
+	socket, isUnix := strings.CutPrefix(address, "unix:")
+	if !isUnix {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid listen address '%s' (must be host:port or unix:/path/to.sock): %w", address, err)
+		}
+		return net.Listen("tcp", address)
+	}
+
+	if socket == "" {
+		return nil, fmt.Errorf("invalid listen address '%s': the socket path is missing", address)
+	}
+	if info, err := os.Lstat(socket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("can not listen on '%s': the file exists and is not a socket", socket)
+		}
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("can not listen on '%s': another server is listening on it", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+
+	// the socket is created with owner-only permissions, so that it is not accessible with the default ones before the chmod
+	listener, err := listenUnix(socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// parseSocketMode parses the permissions of a Unix domain socket as an octal number, e.g. 0660
+func parseSocketMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid --socket-mode '%s' (must be octal permissions, e.g. 0660)", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// limitBody limits the size of all request bodies to max bytes (if max is greater than 0), except for /render/stream
+// which reads any number of records (each of which is limited by --max-data-bytes instead)
+func limitBody(next http.Handler, max int64) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/render/stream" {
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serve accepts connections on the listener until ctx is done, and then shuts down gracefully:
+// the server stops accepting new connections and waits up to shutdownTimeout for in-flight requests to finish
+func serve(ctx context.Context, server *http.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErr <- server.ServeTLS(listener, "", "")
+			return
+		}
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down; waiting for in-flight requests to finish")
+	ready.Store(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("Shutdown complete")
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docopt/docopt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, newServer(handler, serverOptions{}), listener, 5*time.Second)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{string(body), err}
+	}()
+
+	// shut down while the request is in flight
+	<-started
+	cancel()
+
+	res := <-response
+	assert.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.NoError(t, <-done)
+
+	// no new connections are accepted after shutdown
+	_, err = http.Get("http://" + listener.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestLimitBody(t *testing.T) {
+
+	handler := limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		assert.Error(t, err)
+	}), 4)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too long")))
+
+	// streams are not limited
+	handler = limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+	}), 4)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/render/stream", strings.NewReader("{}\n{}\n{}\n")))
+}
+
+func TestUsageDefaults(t *testing.T) {
+
+	defer func(render, stream, batch time.Duration, template, data int64, workers, items int, failures *rateLimiter, slots chan struct{}) {
+		renderTimeout, streamIdleTimeout, batchTimeout = render, stream, batch
+		maxTemplateBytes, maxDataBytes = template, data
+		batchWorkers, maxBatchItems = workers, items
+		authFailureLimiter = failures
+		renderSlots = slots
+	}(renderTimeout, streamIdleTimeout, batchTimeout, maxTemplateBytes, maxDataBytes, batchWorkers, maxBatchItems, authFailureLimiter, renderSlots)
+
+	// every option with a default must be parsed with that default
+	opts, err := docopt.ParseArgs(usage, []string{}, "")
+	assert.NoError(t, err)
+	_, err = parseServerOptions(opts)
+	assert.NoError(t, err)
+	assert.NoError(t, parseLimits(opts))
+	assert.Equal(t, int64(1048576), maxTemplateBytes)
+	assert.Equal(t, 4, batchWorkers)
+	assert.Equal(t, 54*time.Second, batchTimeout)
+	assert.Equal(t, 64, cap(renderSlots))
+	assert.Nil(t, limiter)
+	if assert.NotNil(t, authFailureLimiter) {
+		assert.Equal(t, 0.1, authFailureLimiter.rate)
+	}
+	assert.Equal(t, 60*time.Second, streamIdleTimeout)
+	q, err := parseJobs(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, q.workers)
+	assert.Equal(t, time.Hour, q.retention)
+}
+
+func TestListen(t *testing.T) {
+
+	listener, err := listen("127.0.0.1:0", 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "tcp", listener.Addr().Network())
+		listener.Close()
+	}
+
+	for _, address := range []string{"10000", "unix:", "localhost"} {
+		_, err := listen(address, 0600)
+		assert.Error(t, err, address)
+	}
+
+	// a Unix domain socket gets the permissions, and can be used by HTTP clients
+	socket := filepath.Join(t.TempDir(), "gotmpl.sock")
+	listener, err = listen("unix:"+socket, 0600)
+	if !assert.NoError(t, err) {
+		return
+	}
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	server := &http.Server{Handler: http.HandlerFunc(handleHealthz)}
+	go server.Serve(listener)
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	resp, err := client.Get("http://gotmpl/healthz")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	// a socket which is in use is not replaced
+	_, err = listen("unix:"+socket, 0600)
+	assert.ErrorContains(t, err, "another server is listening")
+	server.Close()
+
+	// a stale socket is replaced
+	stale, _ := net.Listen("unix", socket)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err = listen("unix:"+socket, 0660)
+	if assert.NoError(t, err) {
+		listener.Close()
+	}
+
+	// but a file which is not a socket is not
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, []byte("keep"), 0644)
+	_, err = listen("unix:"+file, 0600)
+	assert.ErrorContains(t, err, "not a socket")
+}
+
+func TestParseSocketMode(t *testing.T) {
+	mode, err := parseSocketMode("0660")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), mode)
+	_, err = parseSocketMode("rw")
+	assert.Error(t, err)
+	_, err = parseSocketMode("1777")
+	assert.Error(t, err)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// listenUnix listens on a Unix domain socket; without umask, its permissions are only restricted once they are set
+func listenUnix(socket string) (net.Listener, error) {
+	return net.Listen("unix", socket)
+}
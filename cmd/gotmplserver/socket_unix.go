@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on a Unix domain socket which is only accessible by the owner until its permissions are set,
+// by creating it with a umask of 0177 (the umask is restored right away, but applies to the whole process meanwhile)
+func listenUnix(socket string) (net.Listener, error) {
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", socket)
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnix(t *testing.T) {
+
+	// even with a permissive umask, the socket is only accessible by its owner when it is created
+	umask := syscall.Umask(0)
+	defer syscall.Umask(umask)
+
+	socket := filepath.Join(t.TempDir(), "gotmpl.sock")
+	listener, err := listenUnix(socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// and the umask of the process is restored
+	assert.Equal(t, 0, syscall.Umask(0))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// streamIdleTimeout is the maximum time to wait for reading each record of a stream, and for writing its result (0 means no limit)
+	streamIdleTimeout = 60 * time.Second
+	// maxStreamRecordBytes is the maximum size of one record of a stream when --max-data-bytes is not set
+	maxStreamRecordBytes = 16 << 20
+)
+
+// StreamResult is one line of the response of POST /render/stream, for the record (line of the request) with the same index
+type StreamResult struct {
+	Index  int        `json:"index"`
+	Output string     `json:"output"`
+	Error  *HttpError `json:"error,omitempty"`
+}
+
+// handleStream handles POST /render/stream which renders one template with each record of a newline-delimited JSON
+// (NDJSON) request body, and streams one NDJSON result line per record. The template is either a named template
+// (name query parameter) or sent as the template query parameter, and options can be set as query parameters.
+//
+// Records are read, rendered and written one at a time, and the result of each record is flushed before the next
+// record is read, so a client which does not read the results will stop the server from reading more records.
+// An error with a record is written as its result line and the stream continues with the next record.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+
+	// Only allow POST method
+	if r.Method != http.MethodPost {
+		w.Header().Add("Allow", "POST")
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	req := RenderRequest{
+		Template: query.Get("template"),
+		Options: RenderOptions{
+			MissingKey:     query.Get("missingkey"),
+			Delimiters:     strings.Fields(query.Get("delimiters")),
+			SchemaName:     query.Get("schemaName"),
+			OutputType:     query.Get("outputType"),
+			ValidateOutput: query.Get("validateOutput") == "true",
+		},
+	}
+	name := query.Get("name")
+	if name != "" && req.Template != "" {
+		writeHttpError(w, HttpError{Reason: ReasonRequestError, Message: "a stream must have either template or name, but not both"})
+		return
+	}
+	tmpl, httpErr := compileBatchTemplate(authClient(r), name, &req)
+	if httpErr != nil {
+		writeHttpError(w, *httpErr)
+		return
+	}
+	// the name is only recorded once the template has been found, so that metrics only have the names of existing templates
+	if name != "" {
+		setTemplateName(w, name)
+	}
+
+	// allow reading more of the request after writing has started (HTTP/1.x)
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+
+	maxRecord := maxStreamRecordBytes
+	if maxDataBytes > 0 {
+		maxRecord = int(maxDataBytes)
+	}
+	reader := bufio.NewReader(r.Body)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	index := 0
+	for {
+		if streamIdleTimeout > 0 {
+			rc.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+			rc.SetWriteDeadline(time.Now().Add(streamIdleTimeout))
+		}
+		record, tooLong, readErr := readRecord(reader, maxRecord)
+		record = bytes.TrimSpace(record)
+
+		if len(record) > 0 || tooLong {
+			result := StreamResult{Index: index}
+			if tooLong {
+				result.Error = &HttpError{Reason: ReasonDataTooLarge, Message: fmt.Sprintf("record is larger than the limit of %d bytes", maxRecord)}
+			} else {
+				req.Data = record
+				// a record waits for a free render slot instead of failing, since the client is already waiting for it
+				rendered, httpErr := render(withRenderWait(r.Context()), w, req, tmpl)
+				if httpErr != nil {
+					result.Error = httpErr
+				} else {
+					result.Output = string(rendered.output)
+				}
+			}
+			if err := encoder.Encode(result); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			index++
+		}
+
+		if readErr == io.EOF {
+			return
+		}
+		if readErr != nil {
+			// the rest of the request can not be read, so end the stream with an error line
+			result := StreamResult{Index: index, Error: &HttpError{Reason: ReasonRequestError, Message: readErr.Error()}}
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(readErr, &maxBytesErr) {
+				result.Error.Reason = ReasonRequestTooLarge
+			}
+			encoder.Encode(result)
+			return
+		}
+	}
+}
+
+// readRecord reads the next line from reader; if the line is longer than max bytes the rest of it is discarded and tooLong is true
+func readRecord(reader *bufio.Reader, max int) (record []byte, tooLong bool, err error) {
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			record = append(record, chunk...)
+			if len(bytes.TrimSpace(record)) > max {
+				record, tooLong = nil, true
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return record, tooLong, err
+		}
+	}
+}
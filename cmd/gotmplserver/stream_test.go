@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleStream(t *testing.T) {
+
+	templates = newRegistry()
+	defer func() { templates = newRegistry() }()
+	templates.put("greeting", `Hi {{ .name }}`, sourceUpload)
+
+	server := httptest.NewServer(instrument("render_stream", handleStream))
+	defer server.Close()
+
+	// write the records one at a time and read each result before writing the next record
+	body, input := io.Pipe()
+	resultsCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(server.URL+"/render/stream?name=greeting", "application/x-ndjson", body)
+		assert.NoError(t, err)
+		resultsCh <- resp
+	}()
+
+	io.WriteString(input, `{"name": "a"}`+"\n")
+	resp := <-resultsCh
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	results := bufio.NewScanner(resp.Body)
+
+	readResult := func() StreamResult {
+		var result StreamResult
+		assert.True(t, results.Scan())
+		assert.NoError(t, json.Unmarshal(results.Bytes(), &result))
+		return result
+	}
+
+	assert.Equal(t, StreamResult{Index: 0, Output: "Hi a"}, readResult())
+
+	io.WriteString(input, "\n{}\n")
+	result := readResult()
+	assert.Equal(t, 1, result.Index)
+	assert.Equal(t, ReasonTemplateRenderingError, result.Error.Reason)
+
+	io.WriteString(input, `{"name": "b"}`+"\n")
+	assert.Equal(t, StreamResult{Index: 2, Output: "Hi b"}, readResult())
+
+	input.Close()
+	assert.False(t, results.Scan())
+	resp.Body.Close()
+}
+
+func TestHandleStreamErrors(t *testing.T) {
+
+	maxDataBytes = 20
+	defer func() { maxDataBytes = 0 }()
+
+	// a record which is too long gets an error line and the stream continues
+	w := httptest.NewRecorder()
+	records := `{"a": 1}` + "\n" + `{"a": "` + strings.Repeat("a", 10000) + `"}` + "\n" + `{"a": 3}`
+	handleStream(w, httptest.NewRequest(http.MethodPost, "/render/stream?template={{.a}}", strings.NewReader(records)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.JSONEq(t, `{"index": 0, "output": "1"}`, lines[0])
+	assert.Contains(t, lines[1], `"reason":"DataTooLarge"`)
+	assert.JSONEq(t, `{"index": 2, "output": "3"}`, lines[2])
+
+	// errors with the template fail the whole request, and the name of a missing template is not recorded for metrics
+	w = httptest.NewRecorder()
+	rec := &responseRecorder{ResponseWriter: w}
+	handleStream(rec, httptest.NewRequest(http.MethodPost, "/render/stream?name=missing", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, rec.template)
+}
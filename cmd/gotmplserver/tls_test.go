@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a generated certificate and key, in both parsed and PEM form
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert generates a certificate signed by parent (or self-signed if parent is nil)
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newTestCA(t *testing.T) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newTestServerCert(t *testing.T, ca *testCert) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+func TestMutualTLS(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	serverCert := newTestServerCert(t, ca)
+	clientCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	os.WriteFile(filepath.Join(dir, "ca.pem"), ca.certPEM, 0644)
+	os.WriteFile(filepath.Join(dir, "tls.crt"), serverCert.certPEM, 0644)
+	os.WriteFile(filepath.Join(dir, "tls.key"), serverCert.keyPEM, 0600)
+
+	config, err := newTLSConfig(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.pem"))
+	assert.NoError(t, err)
+	server := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(clientIdentity(r)))
+	}), serverOptions{})
+	server.TLSConfig = config
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serve(ctx, server, listener, time.Second)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	url := "https://" + listener.Addr().String()
+
+	// a client with a certificate signed by the client CA is identified by its common name
+	clientKeyPair, err := tls.X509KeyPair(clientCert.certPEM, clientCert.keyPEM)
+	assert.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientKeyPair}}}}
+	resp, err := client.Get(url)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "client1", string(body))
+	}
+
+	// a client without a certificate is rejected
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
+
+func TestCertReloader(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := newTestServerCert(t, ca)
+	os.WriteFile(certFile, first.certPEM, 0644)
+	os.WriteFile(keyFile, first.keyPEM, 0600)
+
+	cr, err := newCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	cert, err := cr.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, first.cert.Raw, cert.Certificate[0])
+
+	// replace the files and make sure they look modified
+	second := newTestServerCert(t, ca)
+	os.WriteFile(certFile, second.certPEM, 0644)
+	os.WriteFile(keyFile, second.keyPEM, 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	// the files are not checked again until certCheckInterval has passed
+	cert, _ = cr.GetCertificate(nil)
+	assert.Equal(t, first.cert.Raw, cert.Certificate[0])
+
+	cr.lastCheck = time.Time{}
+	cert, _ = cr.GetCertificate(nil)
+	assert.Equal(t, second.cert.Raw, cert.Certificate[0])
+
+	// a broken key keeps the previous certificate
+	os.WriteFile(keyFile, []byte("broken"), 0600)
+	os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	cr.lastCheck = time.Time{}
+	cert, _ = cr.GetCertificate(nil)
+	assert.Equal(t, second.cert.Raw, cert.Certificate[0])
+}
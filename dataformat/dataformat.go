@@ -0,0 +1,165 @@
+// Package dataformat unmarshals template data from the supported formats (JSON, YAML and XML).
+package dataformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/clbanning/mxj/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Supported data formats
+const (
+	JSON = "json"
+	YAML = "yaml"
+	XML  = "xml"
+)
+
+// FromExtension returns the data format for a file name based on its extension (e.g. ".json", ".yml")
+func FromExtension(path string) (string, error) {
+	switch filepath.Ext(path) {
+	case ".json":
+		return JSON, nil
+	case ".yml", ".yaml":
+		return YAML, nil
+	case ".xml":
+		return XML, nil
+	}
+	return "", fmt.Errorf("unsupported data file extension '%s'", filepath.Ext(path))
+}
+
+// Guess tries to "guess" JSON vs YAML vs XML by looking at the first character of the data.
+func Guess(data []byte) string {
+	data = bytes.TrimSpace(data)
+	switch {
+
+	// unmarshall to map[string]interface requires an object at the top level even though valid JSON can start with an array or a single element value
+	// so here we will only try to detect if the data starts with "{" and assume it will be JSON
+	case bytes.HasPrefix(data, []byte("{")):
+		return JSON
+
+	// beginning with "<" is assumed to be XML
+	case bytes.HasPrefix(data, []byte("<")):
+		return XML
+
+	}
+
+	// otherwise we can just assume it is YAML, I guess ? (since in YAML you can quote key names and stuff..)
+	return YAML
+}
+
+// Unmarshal unmarshals data in the given format to a generic map. If format is empty it will be guessed using Guess.
+func Unmarshal(format string, data []byte) (map[string]interface{}, error) {
+
+	if format == "" {
+		format = Guess(data)
+	}
+
+	m := make(map[string]interface{})
+	var err error
+	switch format {
+	case JSON:
+		err = json.Unmarshal(data, &m)
+	case YAML, "yml":
+		err = yaml.Unmarshal(data, &m)
+	case XML:
+		// use mxj instead of encoding/xml since we want to use generic map[string]interface
+		m, err = mxj.NewMapXml(data)
+	default:
+		err = fmt.Errorf("unsupported data format '%s' (must be one of json, yaml, xml)", format)
+		return m, err
+	}
+	if err != nil {
+		line, column := errorPosition(data, err)
+		return m, &Error{Format: format, Line: line, Column: column, Err: err}
+	}
+	return m, nil
+}
+
+// Validate checks that data is well-formed in the given format. Unlike Unmarshal, any value is allowed at
+// the top level for JSON and YAML (not only objects), and XML is only checked for syntax errors.
+func Validate(format string, data []byte) error {
+	var err error
+	switch format {
+	case JSON:
+		var v interface{}
+		err = json.Unmarshal(data, &v)
+	case YAML, "yml":
+		var v interface{}
+		err = yaml.Unmarshal(data, &v)
+	case XML:
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		for err == nil {
+			_, err = decoder.Token()
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	default:
+		return fmt.Errorf("unsupported data format '%s' (must be one of json, yaml, xml)", format)
+	}
+	if err != nil {
+		line, column := errorPosition(data, err)
+		return &Error{Format: format, Line: line, Column: column, Err: err}
+	}
+	return nil
+}
+
+// Error is an error from unmarshalling data, with the position in the data if it is known
+type Error struct {
+	Format string
+	// Line and Column are 1-based, or 0 if not known
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// yamlLinePattern matches the line number in errors from the YAML parser
+var yamlLinePattern = regexp.MustCompile(`\bline (\d+)\b`)
+
+// errorPosition returns the line and column of an unmarshalling error in data, where it is possible to know
+func errorPosition(data []byte, err error) (line int, column int) {
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var xmlErr *xml.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return offsetPosition(data, syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return offsetPosition(data, typeErr.Offset)
+	case errors.As(err, &xmlErr):
+		return xmlErr.Line, 0
+	}
+	if m := yamlLinePattern.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	return line, 0
+}
+
+// offsetPosition returns the line and column of the byte before offset (encoding/json offsets are just after the error)
+func offsetPosition(data []byte, offset int64) (line int, column int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = 1 + bytes.Count(before, []byte("\n"))
+	column = int(offset) - (bytes.LastIndexByte(before, '\n') + 1)
+	return line, column
+}
@@ -0,0 +1,108 @@
+package dataformat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuess(t *testing.T) {
+	assert.Equal(t, JSON, Guess([]byte(`  {"a": 1}`)))
+	assert.Equal(t, XML, Guess([]byte("\n<a>1</a>")))
+	assert.Equal(t, YAML, Guess([]byte(`a: 1`)))
+	assert.Equal(t, YAML, Guess([]byte(``)))
+}
+
+func TestUnmarshal(t *testing.T) {
+
+	expect := map[string]interface{}{"Data": map[string]interface{}{"aKey": "aValue"}}
+
+	tests := []struct {
+		format, data string
+	}{
+		{format: "", data: `{"Data": {"aKey": "aValue"}}`},
+		{format: JSON, data: `{"Data": {"aKey": "aValue"}}`},
+		{format: "", data: "Data:\n  aKey: aValue\n"},
+		{format: YAML, data: `{"Data": {"aKey": "aValue"}}`},
+		{format: "", data: `<Data><aKey>aValue</aKey></Data>`},
+		{format: XML, data: `<Data><aKey>aValue</aKey></Data>`},
+	}
+
+	for _, tt := range tests {
+		data, err := Unmarshal(tt.format, []byte(tt.data))
+		assert.NoError(t, err, tt.data)
+		assert.EqualValues(t, expect, data, tt.data)
+	}
+
+	data, err := Unmarshal("", []byte(``))
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	_, err = Unmarshal(JSON, []byte(`a: 1`))
+	assert.Error(t, err)
+
+	_, err = Unmarshal("toml", []byte(`a = 1`))
+	assert.Error(t, err)
+}
+
+func TestFromExtension(t *testing.T) {
+	format, err := FromExtension("test.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, YAML, format)
+
+	_, err = FromExtension("test.txt")
+	assert.Error(t, err)
+}
+
+func TestUnmarshalErrorPosition(t *testing.T) {
+
+	tests := []struct {
+		format, data string
+		line, column int
+	}{
+		{format: JSON, data: "{\n  \"a\": x\n}", line: 2, column: 8},
+		{format: JSON, data: `{"a": 1,}`, line: 1, column: 9},
+		{format: YAML, data: "a: 1\nb: [\n", line: 2},
+		{format: XML, data: "<a>\n<b></a>", line: 2},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal(tt.format, []byte(tt.data))
+		var dataErr *Error
+		if assert.ErrorAs(t, err, &dataErr, tt.data) {
+			assert.Equal(t, tt.format, dataErr.Format, tt.data)
+			assert.Equal(t, tt.line, dataErr.Line, tt.data)
+			assert.Equal(t, tt.column, dataErr.Column, tt.data)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		format, data string
+		valid        bool
+		line         int
+	}{
+		{format: JSON, data: `[1, 2]`, valid: true},
+		{format: JSON, data: "{\n\"a\": }", line: 2},
+		{format: YAML, data: "- a\n- b\n", valid: true},
+		{format: YAML, data: "a: [", line: 1},
+		{format: XML, data: `<a><b/></a>`, valid: true},
+		{format: XML, data: "<a>\n<b></a>", line: 2},
+	}
+
+	for _, tt := range tests {
+		err := Validate(tt.format, []byte(tt.data))
+		if tt.valid {
+			assert.NoError(t, err, tt.data)
+			continue
+		}
+		var dataErr *Error
+		if assert.ErrorAs(t, err, &dataErr, tt.data) {
+			assert.Equal(t, tt.line, dataErr.Line, tt.data)
+		}
+	}
+
+	assert.Error(t, Validate("toml", []byte(`a = 1`)))
+}
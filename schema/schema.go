@@ -0,0 +1,112 @@
+// Package schema validates template data against a JSON Schema before it is rendered.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	schema *jsonschema.Schema
+}
+
+// Violation is a single reason that data does not conform to a Schema.
+type Violation struct {
+	// InstanceLocation is a JSON pointer to the invalid value in the data, e.g. "/Data/aKey" ("" is the root)
+	InstanceLocation string `json:"instanceLocation"`
+	// KeywordLocation is a JSON pointer to the schema keyword which failed, e.g. "/properties/Data/required"
+	KeywordLocation string `json:"keywordLocation"`
+	Message         string `json:"message"`
+}
+
+// String formats the Violation as "instanceLocation: message" where the root is shown as "/"
+func (v Violation) String() string {
+	location := v.InstanceLocation
+	if location == "" {
+		location = "/"
+	}
+	return fmt.Sprintf("%s: %s", location, v.Message)
+}
+
+// ValidationError is returned by Validate and contains all of the violations which were found.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+	return "data does not conform to schema: " + strings.Join(messages, "; ")
+}
+
+// Compile parses and compiles a JSON Schema document. The name is only used to identify the schema in error messages.
+//
+// References ($ref) are only resolved within the document itself; loading other files or URLs is not allowed
+// since schemas can be provided by clients of the server.
+func Compile(name string, schemaBytes []byte) (*Schema, error) {
+	url := "gotmpl:///" + name
+	c := jsonschema.NewCompiler()
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("loading external schema %q is not allowed", s)
+	}
+	if err := c.AddResource(url, bytes.NewReader(schemaBytes)); err != nil {
+		return nil, err
+	}
+	s, err := c.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{schema: s}, nil
+}
+
+// Validate checks data against the schema and returns a *ValidationError with all violations if it does not conform.
+//
+// The data is converted to its JSON representation first so that values decoded from YAML or XML
+// (or any other Go types which can be marshalled to JSON) can be validated.
+func (s *Schema) Validate(data interface{}) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(dataBytes))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return err
+	}
+
+	err = s.schema.Validate(doc)
+	if ve, ok := err.(*jsonschema.ValidationError); ok {
+		violations := []Violation{}
+		collectViolations(ve, &violations)
+		sort.SliceStable(violations, func(i, j int) bool {
+			return violations[i].InstanceLocation < violations[j].InstanceLocation
+		})
+		return &ValidationError{Violations: violations}
+	}
+	return err
+}
+
+// collectViolations adds the innermost causes of ve to violations since the outer errors only summarize them
+func collectViolations(ve *jsonschema.ValidationError, violations *[]Violation) {
+	if len(ve.Causes) == 0 {
+		*violations = append(*violations, Violation{
+			InstanceLocation: ve.InstanceLocation,
+			KeywordLocation:  ve.KeywordLocation,
+			Message:          ve.Message,
+		})
+		return
+	}
+	for _, cause := range ve.Causes {
+		collectViolations(cause, violations)
+	}
+}
@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["Data"],
+	"properties": {
+		"Data": {
+			"type": "object",
+			"required": ["aKey"],
+			"properties": {
+				"aKey": {"type": "string"},
+				"count": {"type": "integer", "minimum": 0}
+			}
+		},
+		"items": {"type": "array", "items": {"$ref": "#/$defs/item"}}
+	},
+	"$defs": {
+		"item": {"type": "object", "required": ["name"]}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+
+	s, err := Compile("test.json", []byte(testSchema))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		data   interface{}
+		expect []string
+	}{{
+		data:   map[string]interface{}{"Data": map[string]interface{}{"aKey": "aValue", "count": 2}},
+		expect: nil,
+	}, {
+		data:   map[string]interface{}{},
+		expect: []string{"/: missing properties: 'Data'"},
+	}, {
+		data: map[string]interface{}{
+			"Data":  map[string]interface{}{"anotherKey": "aValue", "count": -1},
+			"items": []interface{}{map[string]interface{}{"name": "one"}, map[string]interface{}{}},
+		},
+		expect: []string{
+			"/Data: missing properties: 'aKey'",
+			"/Data/count: must be >= 0 but found -1",
+			"/items/1: missing properties: 'name'",
+		},
+	}}
+
+	for _, tt := range tests {
+		err := s.Validate(tt.data)
+		if tt.expect == nil {
+			assert.NoError(t, err)
+			continue
+		}
+		if assert.IsType(t, &ValidationError{}, err) {
+			messages := []string{}
+			for _, v := range err.(*ValidationError).Violations {
+				messages = append(messages, v.String())
+			}
+			assert.Equal(t, tt.expect, messages)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := Compile("bad.json", []byte(`{"type": 1}`))
+	assert.Error(t, err)
+
+	_, err = Compile("notjson.json", []byte(`{`))
+	assert.Error(t, err)
+
+	_, err = Compile("ref.json", []byte(`{"$ref": "file:///etc/passwd"}`))
+	assert.ErrorContains(t, err, "not allowed")
+}
@@ -0,0 +1,308 @@
+package template
+
+import (
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Fields returns every data path read by the template, in the order they first appear, for example
+// ".Data.aKey" or ".items[].name" (where "[]" means each element of a range).
+//
+// Paths are followed through with and range blocks, variables and calls to defined templates where the
+// value of dot can be resolved statically; anything accessed via functions (e.g. index or get) or relative
+// to a value returned by a function is not included.
+func (e Engine) Fields(tmpl string) ([]string, error) {
+	t, err := e.Compile(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return t.Fields(), nil
+}
+
+// rangeElem is the path segment used for "every element" of a ranged-over value
+const rangeElem = "[]"
+
+// fieldPath is a chain of keys accessed in the template data, starting from the root (".")
+type fieldPath []string
+
+// String returns the path in template syntax, e.g. ".Data.items[].name"
+func (p fieldPath) String() string {
+	if len(p) == 0 {
+		return "."
+	}
+	var b strings.Builder
+	for _, seg := range p {
+		if seg != rangeElem {
+			b.WriteString(".")
+		}
+		b.WriteString(seg)
+	}
+	return b.String()
+}
+
+// child returns a copy of p with the given segments appended.
+func (p fieldPath) child(segs ...string) fieldPath {
+	c := make(fieldPath, 0, len(p)+len(segs))
+	c = append(c, p...)
+	return append(c, segs...)
+}
+
+// fieldWalker walks the parse tree of a template and records every field path which can be resolved
+// relative to the root data, following dot through with and range blocks, variables and template calls.
+type fieldWalker struct {
+	tmpl   *template.Template
+	seen   map[string]bool
+	paths  []fieldPath
+	vars   []map[string]fieldPath
+	called map[string]bool
+	depth  map[string]int
+}
+
+// maxTemplateDepth is how many calls of the same named template are followed inside each other; a recursive
+// template is followed into its nested call so that the fields of the nested values are recorded too (e.g.
+// ".tree.children[].name"), but no further since each call would add to the path forever
+const maxTemplateDepth = 2
+
+// collectFields returns all of the field paths referenced by t, in the order they first appear.
+func collectFields(t *template.Template) []fieldPath {
+	w := &fieldWalker{
+		tmpl:   t,
+		seen:   make(map[string]bool),
+		called: make(map[string]bool),
+		depth:  make(map[string]int),
+	}
+	if t.Tree != nil {
+		w.walkTemplate(t.Tree, fieldPath{})
+	}
+	return w.paths
+}
+
+func (w *fieldWalker) record(p fieldPath) {
+	if len(p) == 0 || w.seen[p.String()] {
+		return
+	}
+	w.seen[p.String()] = true
+	w.paths = append(w.paths, p)
+}
+
+func (w *fieldWalker) pushScope() { w.vars = append(w.vars, make(map[string]fieldPath)) }
+func (w *fieldWalker) popScope()  { w.vars = w.vars[:len(w.vars)-1] }
+
+func (w *fieldWalker) setVar(name string, p fieldPath) {
+	w.vars[len(w.vars)-1][name] = p
+}
+
+func (w *fieldWalker) lookupVar(name string) fieldPath {
+	for i := len(w.vars) - 1; i >= 0; i-- {
+		if p, ok := w.vars[i][name]; ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// walkTemplate walks a (named) template with a fresh set of variables where $ is the given dot
+func (w *fieldWalker) walkTemplate(tree *parse.Tree, dot fieldPath) {
+	saved := w.vars
+	w.vars = nil
+	w.pushScope()
+	w.setVar("$", dot)
+	w.walk(tree.Root, dot)
+	w.vars = saved
+}
+
+func (w *fieldWalker) walk(node parse.Node, dot fieldPath) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			w.walk(c, dot)
+		}
+	case *parse.ActionNode:
+		w.pipe(n.Pipe, dot)
+	case *parse.IfNode:
+		w.branch(&n.BranchNode, dot, false)
+	case *parse.WithNode:
+		w.branch(&n.BranchNode, dot, false)
+	case *parse.RangeNode:
+		w.branch(&n.BranchNode, dot, true)
+	case *parse.TemplateNode:
+		arg := w.pipe(n.Pipe, dot)
+		// only follow each named template once per value of dot, and only up to maxTemplateDepth calls of
+		// the same template inside each other
+		key := n.Name + "\x00" + arg.String()
+		if arg == nil || w.called[key] || w.depth[n.Name] >= maxTemplateDepth {
+			return
+		}
+		w.called[key] = true
+		if t := w.tmpl.Lookup(n.Name); t != nil && t.Tree != nil {
+			w.depth[n.Name]++
+			w.walkTemplate(t.Tree, arg)
+			w.depth[n.Name]--
+		}
+	}
+}
+
+// branch walks an if, with or range block; with and range change dot for the main list but not the else list
+func (w *fieldWalker) branch(n *parse.BranchNode, dot fieldPath, isRange bool) {
+	w.pushScope()
+	defer w.popScope()
+
+	var p fieldPath
+	if isRange && n.Pipe != nil && len(n.Pipe.Decl) > 0 {
+		// for range the declared variables are set to the element (and index) instead of the pipeline value
+		p = w.pipeValue(n.Pipe, dot)
+		var elem fieldPath
+		if p != nil {
+			elem = p.child(rangeElem)
+		}
+		w.setVar(n.Pipe.Decl[len(n.Pipe.Decl)-1].Ident[0], elem)
+		if len(n.Pipe.Decl) > 1 {
+			w.setVar(n.Pipe.Decl[0].Ident[0], nil)
+		}
+	} else {
+		p = w.pipe(n.Pipe, dot)
+	}
+
+	listDot := dot
+	switch {
+	case n.NodeType == parse.NodeWith:
+		listDot = p
+	case isRange:
+		listDot = nil
+		if p != nil {
+			listDot = p.child(rangeElem)
+		}
+	}
+	w.pushScope()
+	w.walk(n.List, listDot)
+	w.popScope()
+	w.pushScope()
+	w.walk(n.ElseList, dot)
+	w.popScope()
+}
+
+// pipe walks a pipeline, records any declared variables and returns the path of its value (or nil if unknown)
+func (w *fieldWalker) pipe(pipe *parse.PipeNode, dot fieldPath) fieldPath {
+	if pipe == nil {
+		return nil
+	}
+	p := w.pipeValue(pipe, dot)
+	for _, v := range pipe.Decl {
+		if pipe.IsAssign {
+			// assignment to an existing variable; update it wherever it is declared
+			for i := len(w.vars) - 1; i >= 0; i-- {
+				if _, ok := w.vars[i][v.Ident[0]]; ok {
+					w.vars[i][v.Ident[0]] = p
+					break
+				}
+			}
+			continue
+		}
+		w.setVar(v.Ident[0], p)
+	}
+	return p
+}
+
+// pipeValue walks all of the commands in a pipeline and returns the path of the final value (or nil if unknown)
+func (w *fieldWalker) pipeValue(pipe *parse.PipeNode, dot fieldPath) fieldPath {
+	var result fieldPath
+	for _, cmd := range pipe.Cmds {
+		result = nil
+		for _, arg := range cmd.Args {
+			p := w.arg(arg, dot)
+			if len(cmd.Args) == 1 {
+				result = p
+			}
+		}
+	}
+	return result
+}
+
+// arg records and returns the path of a single command argument (or nil if unknown)
+func (w *fieldWalker) arg(node parse.Node, dot fieldPath) fieldPath {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		if dot == nil {
+			return nil
+		}
+		p := dot.child(n.Ident...)
+		w.record(p)
+		return p
+	case *parse.VariableNode:
+		base := w.lookupVar(n.Ident[0])
+		if base == nil {
+			return nil
+		}
+		p := base.child(n.Ident[1:]...)
+		w.record(p)
+		return p
+	case *parse.ChainNode:
+		base := w.arg(n.Node, dot)
+		if base == nil {
+			return nil
+		}
+		p := base.child(n.Field...)
+		w.record(p)
+		return p
+	case *parse.PipeNode:
+		return w.pipe(n, dot)
+	}
+	return nil
+}
+
+// missingFields returns the shortest prefixes of path which are not present in data.
+// Values which are not maps, slices or structs are not looked into.
+func missingFields(data interface{}, path fieldPath) []fieldPath {
+	return missingFieldsFrom(reflect.ValueOf(data), path, 0)
+}
+
+func missingFieldsFrom(v reflect.Value, path fieldPath, i int) []fieldPath {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if i >= len(path) || !v.IsValid() {
+		return nil
+	}
+
+	seg := path[i]
+	if seg == rangeElem {
+		var missing []fieldPath
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < v.Len(); j++ {
+				missing = append(missing, missingFieldsFrom(v.Index(j), path, i+1)...)
+			}
+		case reflect.Map:
+			iter := v.MapRange()
+			for iter.Next() {
+				missing = append(missing, missingFieldsFrom(iter.Value(), path, i+1)...)
+			}
+		}
+		return missing
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		elem := v.MapIndex(reflect.ValueOf(seg).Convert(v.Type().Key()))
+		if !elem.IsValid() {
+			return []fieldPath{path[:i+1]}
+		}
+		return missingFieldsFrom(elem, path, i+1)
+	case reflect.Struct:
+		return missingFieldsFrom(v.FieldByName(seg), path, i+1)
+	}
+	return nil
+}
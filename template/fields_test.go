@@ -0,0 +1,57 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFields(t *testing.T) {
+
+	tests := []struct {
+		tpl    string
+		expect []string
+	}{{
+		tpl:    `{{ $testValue := .Data.aKey -}} A data key value: {{ $testValue }}`,
+		expect: []string{".Data.aKey"},
+	}, {
+		tpl:    `{{ .a.b }}{{ .a }}{{ .a.b }}{{ (.c).d }}`,
+		expect: []string{".a.b", ".a", ".c", ".c.d"},
+	}, {
+		tpl:    `{{ range .items }}{{ .name }}{{ range .tags }}{{ . }}{{ end }}{{ else }}{{ .none }}{{ end }}`,
+		expect: []string{".items", ".items[].name", ".items[].tags", ".none"},
+	}, {
+		tpl:    `{{ range $i, $item := .items }}{{ $item.name }}{{ $.root }}{{ end }}`,
+		expect: []string{".items", ".items[].name", ".root"},
+	}, {
+		tpl:    `{{ with $d := .Data }}{{ .a }}{{ $d.b }}{{ end }}`,
+		expect: []string{".Data", ".Data.a", ".Data.b"},
+	}, {
+		tpl:    `{{ if eq .kind "x" }}{{ .x | upper }}{{ else if .y }}{{ printf "%s" .y.z }}{{ end }}`,
+		expect: []string{".kind", ".x", ".y", ".y.z"},
+	}, {
+		tpl:    `{{ $v := .a }}{{ if .b }}{{ $v = .c }}{{ end }}{{ $v.d }}`,
+		expect: []string{".a", ".b", ".c", ".c.d"},
+	}, {
+		tpl:    `{{ define "person" }}{{ .name }}{{ end }}{{ template "person" .owner }}{{ block "other" .other }}{{ .x }}{{ end }}`,
+		expect: []string{".owner", ".owner.name", ".other", ".other.x"},
+	}, {
+		tpl:    `{{ define "node" }}{{ .name }}{{ range .children }}{{ template "node" . }}{{ end }}{{ end }}{{ template "node" .tree }}`,
+		expect: []string{".tree", ".tree.name", ".tree.children", ".tree.children[].name", ".tree.children[].children"},
+	}, {
+		tpl:    `{{ define "a" }}{{ .x }}{{ template "b" .y }}{{ end }}{{ define "b" }}{{ .z }}{{ template "a" .w }}{{ end }}{{ template "a" . }}`,
+		expect: []string{".x", ".y", ".y.z", ".y.w", ".y.w.x", ".y.w.y", ".y.w.y.z", ".y.w.y.w"},
+	}, {
+		tpl:    `{{ with index .list 0 }}{{ .unknown }}{{ end }}{{ range (get . "m") }}{{ .unknown }}{{ end }}`,
+		expect: []string{".list"},
+	}}
+
+	for _, tt := range tests {
+		fields, err := Engine{}.Fields(tt.tpl)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expect, fields, tt.tpl)
+	}
+
+	_, err := Engine{}.Fields(`{{ .a `)
+	assert.Error(t, err)
+}
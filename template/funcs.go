@@ -3,8 +3,11 @@ package template
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"strings"
+	"sync"
 	"text/template"
+	"text/template/parse"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -12,10 +15,144 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
+// Profile selects which set of functions is available to templates.
+type Profile string
+
+const (
+	// ProfileDefault includes all Sprig functions (apart from the environment variable functions) and the extra functions of this package
+	ProfileDefault Profile = "default"
+	// ProfileHermetic is ProfileDefault without any of the functions which can return a different result for the same input,
+	// i.e. functions which depend on the current time, the time zone of the host, randomness or the network
+	ProfileHermetic Profile = "hermetic"
+)
+
+// Profiles lists all of the available function profiles
+var Profiles = []Profile{ProfileDefault, ProfileHermetic}
+
+// nonHermeticFuncs are removed in ProfileHermetic in addition to the functions which Sprig's HermeticTxtFuncMap removes
+var nonHermeticFuncs = []string{
+	"ago", "randInt", "shuffle",
+	"bcrypt", "htpasswd", "encryptAES",
+	"genPrivateKey", "buildCustomCert", "genCA", "genCAWithKey",
+	"genSelfSignedCert", "genSelfSignedCertWithKey", "genSignedCert", "genSignedCertWithKey",
+}
+
+// nonHermeticFuncNames returns the names of the functions of ProfileDefault which ProfileHermetic excludes
+var nonHermeticFuncNames = sync.OnceValue(func() map[string]bool {
+	hermetic := profileFuncMap(ProfileHermetic, nil)
+	names := make(map[string]bool)
+	for name := range profileFuncMap(ProfileDefault, nil) {
+		if _, ok := hermetic[name]; !ok {
+			names[name] = true
+		}
+	}
+	return names
+})
+
+// usedFuncs returns the names of the functions which are called by t and the templates associated with it
+func usedFuncs(t *template.Template) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IdentifierNode:
+			if !seen[n.Ident] {
+				seen[n.Ident] = true
+				names = append(names, n.Ident)
+			}
+		}
+	}
+	for _, associated := range t.Templates() {
+		if associated.Tree != nil {
+			walk(associated.Tree.Root)
+		}
+	}
+	return names
+}
+
+// ParseProfile returns the Profile matching the given string. An empty string is interpreted as ProfileDefault.
+func ParseProfile(str string) (Profile, error) {
+	if str == "" {
+		return ProfileDefault, nil
+	}
+	for _, p := range Profiles {
+		if Profile(str) == p {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported function profile '%s' (must be one of default, hermetic)", str)
+}
+
 // funcMap returns a mapping of all of the functions that Engine has.
 func funcMap() template.FuncMap {
+	return profileFuncMap(ProfileDefault, nil)
+}
+
+// DefaultLocation is the time zone which toLocalDateTime uses when the Engine does not set a Location
+const DefaultLocation = "Europe/Stockholm"
+
+// ParseLocation returns the time zone with the given location name (e.g. "Europe/Stockholm") or offset (e.g. "UTC+2", "-0700")
+func ParseLocation(str string) (*time.Location, error) {
+	if strings.TrimSpace(str) == "" {
+		return nil, fmt.Errorf("time zone must not be empty")
+	}
+	location, err := parseTzOffset(str)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s' (must be a location name or an offset, e.g. UTC+2)", str)
+	}
+	return location, nil
+}
+
+// profileFuncMap returns a mapping of the functions that Engine has in the given profile,
+// where toLocalDateTime uses the given local time zone (DefaultLocation if nil).
+func profileFuncMap(profile Profile, local *time.Location) template.FuncMap {
+	if local == nil {
+		local, _ = time.LoadLocation(DefaultLocation)
+	}
+
 	// use Sprig's TxtFuncMap as a base
 	f := sprig.TxtFuncMap()
+	if profile == ProfileHermetic {
+		f = sprig.HermeticTxtFuncMap()
+		for _, name := range nonHermeticFuncs {
+			delete(f, name)
+		}
+	}
 
 	// remove environment variable stuff -- these should not be used for our case
 	delete(f, "env")
@@ -23,8 +160,10 @@ func funcMap() template.FuncMap {
 
 	// Add some extra functionality
 	extra := template.FuncMap{
-		"toUTCDateTime":   toUTCDateTime,
-		"toLocalDateTime": toLocalDateTime,
+		"toUTCDateTime": toUTCDateTime,
+		"toLocalDateTime": func(str string, locationInOut ...string) string {
+			return toLocalDateTime(local, str, locationInOut...)
+		},
 
 		"toToml":        toTOML,
 		"toYaml":        toYAML,
@@ -53,22 +192,20 @@ func toUTCDateTime(str string, locationIn ...string) string {
 	if len(locationIn) > 0 && strings.TrimSpace(locationIn[0]) != "" {
 		intzstr = locationIn[0]
 	}
-	return toLocalDateTime(str, intzstr, "UTC")
+	return toLocalDateTime(time.UTC, str, intzstr, "UTC")
 }
 
 // toLocalDateTime converts many recognized datetime string formats to an ISO8601-formatted string in a local time zone
 //
 // The optional second string parameter can be provided as a time zone location name or offset to interpret
 // the incoming datetime str with, but will be used only in case the provided datetime str value does not
-// already specify the time offset information in one of the recognized formats. If omitted, a system default will be used.
+// already specify the time offset information in one of the recognized formats. If omitted, local will be used.
 //
 // The optional third string parameter can be provided as the desired target time zone to convert the input
-// datetime string to. If omitted, a system default will be used.
-func toLocalDateTime(str string, locationInOut ...string) string {
+// datetime string to. If omitted, local will be used.
+func toLocalDateTime(local *time.Location, str string, locationInOut ...string) string {
 
-	// "Local" defaults to Europe/Stockholm TODO: set via env or config??
-	intz, _ := time.LoadLocation("Europe/Stockholm")
-	outtz, _ := time.LoadLocation("Europe/Stockholm")
+	intz, outtz := local, local
 	if len(locationInOut) > 0 && strings.TrimSpace(locationInOut[0]) != "" {
 		parsedtz, err := parseTzOffset(locationInOut[0])
 		if err != nil {
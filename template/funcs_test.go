@@ -232,3 +232,36 @@ func TestMergeFromHelm(t *testing.T) {
 	}
 	assert.Equal(t, expected, dict["dst"])
 }
+
+func TestProfiles(t *testing.T) {
+
+	defaultFuncs := profileFuncMap(ProfileDefault, nil)
+	hermeticFuncs := profileFuncMap(ProfileHermetic, nil)
+
+	for _, name := range []string{"now", "date", "randAlpha", "uuidv4", "randInt", "shuffle", "genCA", "getHostByName"} {
+		assert.Contains(t, defaultFuncs, name)
+		assert.NotContains(t, hermeticFuncs, name)
+	}
+	for _, name := range []string{"env", "expandenv"} {
+		assert.NotContains(t, defaultFuncs, name)
+		assert.NotContains(t, hermeticFuncs, name)
+	}
+	for _, name := range []string{"upper", "toJson", "sha256sum", "toUTCDateTime", "toYaml"} {
+		assert.Contains(t, hermeticFuncs, name)
+	}
+
+	p, err := ParseProfile("")
+	assert.NoError(t, err)
+	assert.Equal(t, ProfileDefault, p)
+	p, err = ParseProfile("hermetic")
+	assert.NoError(t, err)
+	assert.Equal(t, ProfileHermetic, p)
+	_, err = ParseProfile("unsafe")
+	assert.Error(t, err)
+
+	var b strings.Builder
+	_, err = Engine{Profile: ProfileHermetic}.Compile(`{{ now }}`)
+	assert.Error(t, err)
+	assert.NoError(t, Engine{Profile: ProfileHermetic}.Render(`{{ "a" | upper }}`, nil, &b))
+	assert.Equal(t, "A", b.String())
+}
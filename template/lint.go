@@ -0,0 +1,241 @@
+package template
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// Severity levels of a lint Issue
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Rules which can be reported by Lint
+const (
+	RuleSyntax            = "syntax"
+	RuleUnknownFunction   = "unknown-function"
+	RuleUndefinedTemplate = "undefined-template"
+	RuleUnusedDefine      = "unused-define"
+	RuleShadowedVariable  = "shadowed-variable"
+)
+
+// builtins are the functions which are always available in text/template
+// see: https://pkg.go.dev/text/template#hdr-Functions
+var builtins = map[string]bool{
+	"and": true, "call": true, "html": true, "index": true, "slice": true, "js": true, "len": true,
+	"not": true, "or": true, "print": true, "printf": true, "println": true, "urlquery": true,
+	"eq": true, "ge": true, "gt": true, "le": true, "lt": true, "ne": true,
+}
+
+// Source is a named template text, for example the contents of a template file.
+type Source struct {
+	Name string
+	Text string
+}
+
+// Issue is a problem found in a template by Lint.
+type Issue struct {
+	Name     string `json:"name"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// String formats the Issue similar to a compiler error, e.g. "test.tmpl:2:5: error: function "foo" not defined (unknown-function)"
+func (i Issue) String() string {
+	pos := fmt.Sprintf("%s:%d", i.Name, i.Line)
+	if i.Column > 0 {
+		pos += ":" + strconv.Itoa(i.Column)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", pos, i.Severity, i.Message, i.Rule)
+}
+
+// Lint parses the given templates as one set without executing them and returns all issues found, sorted by position:
+//   - syntax errors (only the first syntax error per source can be reported since parsing stops there)
+//   - calls to functions which are not available when rendering
+//   - template calls to names which are not defined in any of the sources
+//   - define blocks which are never called from any of the sources
+//   - variables declared with := which shadow a variable of the same name
+func (e Engine) Lint(sources ...Source) []Issue {
+	issues := []Issue{}
+	funcs := e.funcs()
+	treeSet := make(map[string]*parse.Tree)
+
+	for _, src := range sources {
+		tree := parse.New(src.Name)
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(src.Text, e.LeftDelim, e.RightDelim, treeSet); err != nil {
+			issues = append(issues, syntaxIssue(src.Name, err))
+		}
+	}
+
+	// defined templates which are not the main template of a source
+	mains := make(map[string]bool)
+	for _, src := range sources {
+		mains[src.Name] = true
+	}
+
+	l := &linter{funcs: funcs, treeSet: treeSet, called: make(map[string]bool)}
+	names := make([]string, 0, len(treeSet))
+	for name := range treeSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tree := treeSet[name]
+		l.tree = tree
+		l.vars = []map[string]parse.Node{{"$": nil}}
+		l.walk(tree.Root)
+	}
+
+	for _, name := range names {
+		if !mains[name] && !l.called[name] {
+			l.report(treeSet[name], treeSet[name].Root, SeverityWarning, RuleUnusedDefine, fmt.Sprintf("template %q is defined but never used", name))
+		}
+	}
+
+	issues = append(issues, l.issues...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return issues
+}
+
+// parseErrorPattern matches the "template: name:line: message" format used by text/template/parse errors
+var parseErrorPattern = regexp.MustCompile(`^template: (.*?):(\d+):(?:(\d+):)? (.*)$`)
+
+// syntaxIssue converts a parse error into an Issue, including the position if it can be found in the message.
+func syntaxIssue(name string, err error) Issue {
+	issue := Issue{Name: name, Severity: SeverityError, Rule: RuleSyntax, Message: err.Error()}
+	if m := parseErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		issue.Name = m[1]
+		issue.Line, _ = strconv.Atoi(m[2])
+		issue.Column, _ = strconv.Atoi(m[3])
+		issue.Message = m[4]
+	}
+	return issue
+}
+
+// linter walks parse trees and collects issues
+type linter struct {
+	funcs   map[string]interface{}
+	treeSet map[string]*parse.Tree
+	tree    *parse.Tree
+	vars    []map[string]parse.Node
+	called  map[string]bool
+	issues  []Issue
+}
+
+// report adds an Issue positioned at the given node of the tree
+func (l *linter) report(tree *parse.Tree, node parse.Node, severity, rule, message string) {
+	issue := Issue{Name: tree.ParseName, Severity: severity, Rule: rule, Message: message}
+	issue.Line, issue.Column = position(tree, node)
+	l.issues = append(l.issues, issue)
+}
+
+// position returns the line and column (both starting at 1) of node within tree
+func position(tree *parse.Tree, node parse.Node) (line int, column int) {
+	location, _ := tree.ErrorContext(node)
+	// location is formatted as "name:line:byteOffset" where the offset within the line starts at 0
+	parts := strings.Split(location, ":")
+	if len(parts) >= 3 {
+		line, _ = strconv.Atoi(parts[len(parts)-2])
+		column, _ = strconv.Atoi(parts[len(parts)-1])
+		column++
+	}
+	return line, column
+}
+
+func (l *linter) walk(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			l.walk(c)
+		}
+	case *parse.ActionNode:
+		l.pipe(n.Pipe)
+	case *parse.IfNode:
+		l.branch(&n.BranchNode)
+	case *parse.WithNode:
+		l.branch(&n.BranchNode)
+	case *parse.RangeNode:
+		l.branch(&n.BranchNode)
+	case *parse.TemplateNode:
+		l.called[n.Name] = true
+		if _, ok := l.treeSet[n.Name]; !ok {
+			l.report(l.tree, n, SeverityError, RuleUndefinedTemplate, fmt.Sprintf("template %q is not defined", n.Name))
+		}
+		l.pipe(n.Pipe)
+	}
+}
+
+// branch walks an if, with or range block where variables declared in the pipeline are scoped to the block
+func (l *linter) branch(n *parse.BranchNode) {
+	l.vars = append(l.vars, make(map[string]parse.Node))
+	l.pipe(n.Pipe)
+	for _, list := range []*parse.ListNode{n.List, n.ElseList} {
+		l.vars = append(l.vars, make(map[string]parse.Node))
+		l.walk(list)
+		l.vars = l.vars[:len(l.vars)-1]
+	}
+	l.vars = l.vars[:len(l.vars)-1]
+}
+
+func (l *linter) pipe(pipe *parse.PipeNode) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			l.arg(arg)
+		}
+	}
+	if pipe.IsAssign {
+		return
+	}
+	for _, v := range pipe.Decl {
+		name := v.Ident[0]
+		for i := len(l.vars) - 1; i >= 0; i-- {
+			if prev, ok := l.vars[i][name]; ok {
+				msg := fmt.Sprintf("variable %s shadows an earlier declaration", name)
+				if prev != nil {
+					line, column := position(l.tree, prev)
+					msg += fmt.Sprintf(" at %d:%d", line, column)
+				}
+				l.report(l.tree, v, SeverityWarning, RuleShadowedVariable, msg)
+				break
+			}
+		}
+		l.vars[len(l.vars)-1][name] = v
+	}
+}
+
+func (l *linter) arg(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.IdentifierNode:
+		if _, ok := l.funcs[n.Ident]; !ok && !builtins[n.Ident] {
+			l.report(l.tree, n, SeverityError, RuleUnknownFunction, fmt.Sprintf("function %q not defined", n.Ident))
+		}
+	case *parse.ChainNode:
+		l.arg(n.Node)
+	case *parse.PipeNode:
+		l.pipe(n)
+	}
+}
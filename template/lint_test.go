@@ -0,0 +1,55 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+
+	tests := []struct {
+		tpl    string
+		expect []Issue
+	}{{
+		tpl:    `{{ $testValue := .Data.aKey -}}` + "\n" + `A data key value: {{ $testValue | upper }}`,
+		expect: []Issue{},
+	}, {
+		tpl: "line one\n{{ if .a }}\n{{ end }}{{ end }}",
+		expect: []Issue{
+			{Name: "test", Line: 3, Severity: SeverityError, Rule: RuleSyntax, Message: "unexpected {{end}}"},
+		},
+	}, {
+		tpl: "{{ .a | notAFunction }}\n  {{ env \"HOME\" }}",
+		expect: []Issue{
+			{Name: "test", Line: 1, Column: 9, Severity: SeverityError, Rule: RuleUnknownFunction, Message: `function "notAFunction" not defined`},
+			{Name: "test", Line: 2, Column: 6, Severity: SeverityError, Rule: RuleUnknownFunction, Message: `function "env" not defined`},
+		},
+	}, {
+		tpl: `{{ define "used" }}x{{ end }}{{ define "unused" }}y{{ end }}{{ template "used" . }}{{ template "missing" }}`,
+		expect: []Issue{
+			{Name: "test", Line: 1, Column: 51, Severity: SeverityWarning, Rule: RuleUnusedDefine, Message: `template "unused" is defined but never used`},
+			{Name: "test", Line: 1, Column: 96, Severity: SeverityError, Rule: RuleUndefinedTemplate, Message: `template "missing" is not defined`},
+		},
+	}, {
+		tpl: `{{ $x := 1 }}{{ if .a }}{{ $x := 2 }}{{ $x = 3 }}{{ end }}{{ range $i, $v := .items }}{{ $v }}{{ end }}`,
+		expect: []Issue{
+			{Name: "test", Line: 1, Column: 28, Severity: SeverityWarning, Rule: RuleShadowedVariable, Message: "variable $x shadows an earlier declaration at 1:4"},
+		},
+	}}
+
+	for _, tt := range tests {
+		issues := Engine{}.Lint(Source{Name: "test", Text: tt.tpl})
+		assert.Equal(t, tt.expect, issues, tt.tpl)
+	}
+}
+
+func TestLintMultipleSources(t *testing.T) {
+	issues := Engine{}.Lint(
+		Source{Name: "main.tmpl", Text: `{{ template "partial" . }}`},
+		Source{Name: "partials.tmpl", Text: `{{ define "partial" }}{{ .a }}{{ end }}`},
+		Source{Name: "bad.tmpl", Text: `{{ .a `},
+	)
+	assert.Len(t, issues, 1)
+	assert.Equal(t, "bad.tmpl:1: error: unclosed action (syntax)", issues[0].String())
+}
@@ -1,17 +1,208 @@
 package template
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"strconv"
 	"text/template"
+	"time"
 )
 
 // Hard-coded "name" for the temporary Template instance that will be created
 const templateName = "gotmpl"
 
+// MissingKey controls what happens when a template indexes a map with a key that is not present in the map.
+type MissingKey string
+
+const (
+	// MissingKeyError stops execution immediately with an error (this is the default)
+	MissingKeyError MissingKey = "error"
+	// MissingKeyZero returns the zero value of the map's element type
+	MissingKeyZero MissingKey = "zero"
+	// MissingKeyDefault does nothing and continues execution; missing values are printed as "<no value>"
+	MissingKeyDefault MissingKey = "default"
+	// MissingKeyInvalid is the same as MissingKeyDefault
+	MissingKeyInvalid MissingKey = "invalid"
+)
+
+// ParseMissingKey returns the MissingKey policy matching the given string.
+// An empty string is interpreted as MissingKeyError.
+func ParseMissingKey(str string) (MissingKey, error) {
+	switch MissingKey(str) {
+	case "":
+		return MissingKeyError, nil
+	case MissingKeyError, MissingKeyZero, MissingKeyDefault, MissingKeyInvalid:
+		return MissingKey(str), nil
+	}
+	return "", fmt.Errorf("unsupported missing key policy '%s' (must be one of error, zero, default, invalid)", str)
+}
+
+// Engine holds the options which are used when rendering templates.
+// The zero value is ready to use and behaves the same as Render.
+type Engine struct {
+	// MissingKey sets the policy for map keys which are not present in the data (default: MissingKeyError)
+	MissingKey MissingKey
+	// LeftDelim and RightDelim set the action delimiters (default: "{{" and "}}")
+	LeftDelim  string
+	RightDelim string
+	// Profile selects the functions which are available to templates (default: ProfileDefault)
+	Profile Profile
+	// Location is the local time zone of toLocalDateTime (default: DefaultLocation)
+	Location *time.Location
+}
+
 // Creates a temporary instance of a Text Template based on a string-representation of the desired template,
 // executes the template using the given data interface{}, and writes the result to the given Writer.
 // - sprig v3 functions are available
 // - missing keys will result in an error
 func Render(tmpl string, data interface{}, w io.Writer) error {
-	return template.Must(template.New(templateName).Option("missingkey=error").Funcs(funcMap()).Parse(tmpl)).Execute(w, data)
+	return Engine{}.Render(tmpl, data, w)
+}
+
+// Render works the same as the package-level Render but uses the options set on the Engine.
+func (e Engine) Render(tmpl string, data interface{}, w io.Writer) error {
+	return template.Must(e.parse(tmpl)).Execute(w, data)
+}
+
+// Template is a parsed template which can be executed many times.
+type Template struct {
+	tmpl   *template.Template
+	text   string
+	engine Engine
+}
+
+// Compile parses tmpl using the options set on the Engine so that it can be executed many times.
+// Unlike Render, a template which cannot be parsed returns an error instead of panicking.
+func (e Engine) Compile(tmpl string) (*Template, error) {
+	t, err := e.parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{tmpl: t, text: tmpl, engine: e}, nil
+}
+
+// Execute executes the template using the given data interface{}, and writes the result to the given Writer.
+func (t *Template) Execute(data interface{}, w io.Writer) error {
+	return t.tmpl.Execute(w, data)
+}
+
+// WithMissingKey returns a copy of the template which uses a different missing key policy.
+func (t *Template) WithMissingKey(missingKey MissingKey) (*Template, error) {
+	clone, err := t.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	engine := t.engine
+	engine.MissingKey = missingKey
+	return &Template{tmpl: clone.Option("missingkey=" + string(missingKey)), text: t.text, engine: engine}, nil
+}
+
+// Hash returns a hash of the template text and the Engine options it was compiled with, so that two templates
+// with the same Hash give the same output for the same data (unless they are not Hermetic).
+func (t *Template) Hash() string {
+	missingKey := t.engine.MissingKey
+	if missingKey == "" {
+		missingKey = MissingKeyError
+	}
+	profile := t.engine.Profile
+	if profile == "" {
+		profile = ProfileDefault
+	}
+	location := DefaultLocation
+	if t.engine.Location != nil {
+		location = t.engine.Location.String()
+	}
+	h := sha256.New()
+	for _, s := range []string{t.text, string(missingKey), t.engine.LeftDelim, t.engine.RightDelim, string(profile), location} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hermetic returns true unless the template calls any function which can return a different result for the same
+// data, i.e. any of the functions which ProfileHermetic excludes (such as now, randAlpha or uuidv4).
+func (t *Template) Hermetic() bool {
+	excluded := nonHermeticFuncNames()
+	for _, name := range usedFuncs(t.tmpl) {
+		if excluded[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Fields returns every data path read by the template; see Engine.Fields.
+func (t *Template) Fields() []string {
+	fields := []string{}
+	for _, path := range collectFields(t.tmpl) {
+		fields = append(fields, path.String())
+	}
+	return fields
+}
+
+// MissingKeys returns the data paths referenced by the template which are not present in the given data; see Engine.MissingKeys.
+func (t *Template) MissingKeys(data interface{}) []string {
+	missing := []string{}
+	seen := make(map[string]bool)
+	for _, path := range collectFields(t.tmpl) {
+		for _, m := range missingFields(data, path) {
+			if !seen[m.String()] {
+				seen[m.String()] = true
+				missing = append(missing, m.String())
+			}
+		}
+	}
+	return missing
+}
+
+// MissingKeys returns the data paths referenced by the template which are not present in the given data,
+// for example ".Data.aKey" or ".items[].name" (where "[]" means at least one element of a range).
+//
+// This is meant as a report to go along with the lenient MissingKeyZero and MissingKeyDefault policies.
+// Only paths which can be resolved statically are checked, so keys accessed via functions such as
+// index or get are not included, and keys inside conditional blocks are reported even if the block
+// would not be executed.
+func (e Engine) MissingKeys(tmpl string, data interface{}) ([]string, error) {
+	t, err := e.Compile(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return t.MissingKeys(data), nil
+}
+
+// ErrorPosition returns the 1-based line and column in the template of a parse or execution error,
+// or 0 if they are not known (parse errors usually only have a line).
+func ErrorPosition(err error) (line int, column int) {
+	m := parseErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, 0
+	}
+	line, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		// execution errors use the byte offset within the line, which starts at 0
+		column, _ = strconv.Atoi(m[3])
+		column++
+	}
+	return line, column
+}
+
+// parse creates a new Template using the options from the Engine and parses tmpl as its body.
+func (e Engine) parse(tmpl string) (*template.Template, error) {
+	missingKey := e.MissingKey
+	if missingKey == "" {
+		missingKey = MissingKeyError
+	}
+	return template.New(templateName).Delims(e.LeftDelim, e.RightDelim).Option("missingkey=" + string(missingKey)).Funcs(e.funcs()).Parse(tmpl)
+}
+
+// funcs returns the functions of the Engine's Profile
+func (e Engine) funcs() template.FuncMap {
+	profile := e.Profile
+	if profile == "" {
+		profile = ProfileDefault
+	}
+	return profileFuncMap(profile, e.Location)
 }
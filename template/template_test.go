@@ -0,0 +1,225 @@
+package template
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineMissingKey(t *testing.T) {
+
+	data := map[string]interface{}{"Data": map[string]interface{}{"aKey": "aValue"}}
+
+	tests := []struct {
+		missingKey MissingKey
+		expect     string
+		err        bool
+	}{{
+		missingKey: "",
+		err:        true,
+	}, {
+		missingKey: MissingKeyError,
+		err:        true,
+	}, {
+		missingKey: MissingKeyZero,
+		expect:     "aValue <no value>",
+	}, {
+		missingKey: MissingKeyDefault,
+		expect:     "aValue <no value>",
+	}, {
+		missingKey: MissingKeyInvalid,
+		expect:     "aValue <no value>",
+	}}
+
+	for _, tt := range tests {
+		var b strings.Builder
+		err := Engine{MissingKey: tt.missingKey}.Render(`{{ .Data.aKey }} {{ .Data.anotherKey }}`, data, &b)
+		if tt.err {
+			assert.Error(t, err, string(tt.missingKey))
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expect, b.String(), string(tt.missingKey))
+	}
+}
+
+func TestEngineDelims(t *testing.T) {
+	var b strings.Builder
+	err := Engine{LeftDelim: "[[", RightDelim: "]]"}.Render(`{{ .a }} [[ .a | upper ]]`, map[string]interface{}{"a": "b"}, &b)
+	assert.NoError(t, err)
+	assert.Equal(t, "{{ .a }} B", b.String())
+}
+
+func TestEngineLocation(t *testing.T) {
+	data := map[string]interface{}{"a": "2023-07-08T12:00:00Z"}
+
+	var b strings.Builder
+	assert.NoError(t, Engine{}.Render(`{{ toLocalDateTime .a }}`, data, &b))
+	assert.Equal(t, "2023-07-08T14:00:00.000+02:00", b.String())
+
+	location, err := ParseLocation("UTC-5")
+	assert.NoError(t, err)
+	b.Reset()
+	assert.NoError(t, Engine{Location: location}.Render(`{{ toLocalDateTime .a }} {{ toUTCDateTime .a }}`, data, &b))
+	assert.Equal(t, "2023-07-08T07:00:00.000-05:00 2023-07-08T12:00:00.000Z", b.String())
+
+	_, err = ParseLocation("Nowhere/Special")
+	assert.Error(t, err)
+	_, err = ParseLocation("")
+	assert.Error(t, err)
+}
+
+func TestCompile(t *testing.T) {
+
+	tmpl, err := Engine{}.Compile(`{{ .Data.aKey }}{{ .Data.anotherKey }}`)
+	assert.NoError(t, err)
+
+	var b strings.Builder
+	err = tmpl.Execute(map[string]interface{}{"Data": map[string]interface{}{"aKey": "aValue", "anotherKey": "!"}}, &b)
+	assert.NoError(t, err)
+	assert.Equal(t, "aValue!", b.String())
+
+	data := map[string]interface{}{"Data": map[string]interface{}{"aKey": "aValue"}}
+	b.Reset()
+	assert.Error(t, tmpl.Execute(data, &b))
+
+	zero, err := tmpl.WithMissingKey(MissingKeyZero)
+	assert.NoError(t, err)
+	b.Reset()
+	assert.NoError(t, zero.Execute(data, &b))
+	assert.Equal(t, "aValue<no value>", b.String())
+	assert.Equal(t, []string{".Data.anotherKey"}, zero.MissingKeys(data))
+
+	// the original template should not be changed
+	b.Reset()
+	assert.Error(t, tmpl.Execute(data, &b))
+
+	_, err = Engine{}.Compile(`{{ .Data.aKey `)
+	assert.Error(t, err)
+}
+
+func TestTemplateHash(t *testing.T) {
+	compile := func(e Engine, text string) string {
+		tmpl, err := e.Compile(text)
+		assert.NoError(t, err)
+		return tmpl.Hash()
+	}
+	hash := compile(Engine{}, `{{ .a }}`)
+	assert.Equal(t, hash, compile(Engine{MissingKey: MissingKeyError, Profile: ProfileDefault}, `{{ .a }}`))
+	assert.NotEqual(t, hash, compile(Engine{}, `{{ .b }}`))
+	assert.NotEqual(t, hash, compile(Engine{MissingKey: MissingKeyZero}, `{{ .a }}`))
+	assert.NotEqual(t, hash, compile(Engine{Profile: ProfileHermetic}, `{{ .a }}`))
+	assert.NotEqual(t, hash, compile(Engine{Location: time.UTC}, `{{ .a }}`))
+
+	tmpl, _ := Engine{}.Compile(`{{ .a }}`)
+	zero, _ := tmpl.WithMissingKey(MissingKeyZero)
+	assert.Equal(t, compile(Engine{MissingKey: MissingKeyZero}, `{{ .a }}`), zero.Hash())
+}
+
+func TestTemplateHermetic(t *testing.T) {
+	tests := []struct {
+		tmpl     string
+		hermetic bool
+	}{
+		{`{{ .a | upper }} {{ toLocalDateTime .b }} {{ trim .c }}`, true},
+		{`{{ now }}`, false},
+		{`{{ if .a }}{{ else }}{{ randAlpha 5 }}{{ end }}`, false},
+		{`{{ range .a }}{{ (uuidv4) | upper }}{{ end }}`, false},
+		{`{{ define "x" }}{{ ago .a }}{{ end }}{{ template "x" . }}`, false},
+	}
+	for _, tt := range tests {
+		tmpl, err := Engine{}.Compile(tt.tmpl)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.hermetic, tmpl.Hermetic(), tt.tmpl)
+	}
+}
+
+func TestParseMissingKey(t *testing.T) {
+	m, err := ParseMissingKey("")
+	assert.NoError(t, err)
+	assert.Equal(t, MissingKeyError, m)
+
+	m, err = ParseMissingKey("zero")
+	assert.NoError(t, err)
+	assert.Equal(t, MissingKeyZero, m)
+
+	_, err = ParseMissingKey("ignore")
+	assert.Error(t, err)
+}
+
+func TestMissingKeys(t *testing.T) {
+
+	data := map[string]interface{}{
+		"Data": map[string]interface{}{"aKey": "aValue"},
+		"items": []interface{}{
+			map[string]interface{}{"name": "one", "value": 1},
+			map[string]interface{}{"name": "two"},
+		},
+		"empty": nil,
+		"tree": map[string]interface{}{
+			"name":     "root",
+			"children": []interface{}{map[string]interface{}{"name": "a", "children": []interface{}{}}},
+		},
+	}
+
+	tests := []struct {
+		tpl    string
+		expect []string
+	}{{
+		tpl:    `{{ .Data.aKey }}`,
+		expect: []string{},
+	}, {
+		tpl:    `{{ .Data.anotherKey }} {{ .Other.key }} {{ .Other.key2 }}`,
+		expect: []string{".Data.anotherKey", ".Other"},
+	}, {
+		tpl:    `{{ range .items }}{{ .name }}{{ .value }}{{ $.Data.aKey }}{{ $.Data.b }}{{ end }}`,
+		expect: []string{".items[].value", ".Data.b"},
+	}, {
+		tpl:    `{{ range $i, $item := .items }}{{ $item.name }}{{ $item.other }}{{ end }}`,
+		expect: []string{".items[].other"},
+	}, {
+		tpl:    `{{ with .Data }}{{ .aKey }}{{ .b }}{{ else }}{{ .c }}{{ end }}`,
+		expect: []string{".Data.b", ".c"},
+	}, {
+		tpl:    `{{ $d := .Data }}{{ $d.aKey }}{{ $d.b | upper }}`,
+		expect: []string{".Data.b"},
+	}, {
+		tpl:    `{{ define "sub" }}{{ .aKey }}{{ .b }}{{ end }}{{ template "sub" .Data }}`,
+		expect: []string{".Data.b"},
+	}, {
+		tpl:    `{{ .empty.key }}{{ index .Data "b" }}`,
+		expect: []string{},
+	}, {
+		// a recursive template is followed into its nested call
+		tpl:    `{{ define "node" }}{{ .name }}{{ .value }}{{ range .children }}{{ template "node" . }}{{ end }}{{ end }}{{ template "node" .tree }}`,
+		expect: []string{".tree.value", ".tree.children[].value"},
+	}}
+
+	for _, tt := range tests {
+		missing, err := Engine{MissingKey: MissingKeyZero}.MissingKeys(tt.tpl, data)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expect, missing, tt.tpl)
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+
+	_, err := Engine{}.Compile("a\n{{ .a ")
+	line, column := ErrorPosition(err)
+	assert.Equal(t, 2, line)
+	assert.Equal(t, 0, column)
+
+	tmpl, _ := Engine{}.Compile("a\n  {{ fail \"x\" }}")
+	err = tmpl.Execute(map[string]interface{}{}, io.Discard)
+	line, column = ErrorPosition(err)
+	assert.Equal(t, 2, line)
+	assert.Equal(t, 6, column)
+
+	line, column = ErrorPosition(errors.New("other"))
+	assert.Equal(t, 0, line)
+	assert.Equal(t, 0, column)
+}